@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	_ "net/http/pprof"
 
 	"github.com/rutkin/url-shortener/internal/app"
 	"github.com/rutkin/url-shortener/internal/app/config"
 	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
 )
 
 var (
@@ -30,7 +32,14 @@ func main() {
 		panic(err)
 	}
 
-	server, err := app.NewServer()
+	shutdownTracing, err := tracing.Initialize(context.Background(), config.ServerConfig.TracingExporter,
+		config.ServerConfig.TracingEndpoint, config.ServerConfig.TracingFile, buildVersion)
+	if err != nil {
+		panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	server, err := app.NewServer(models.BuildInfo{Version: buildVersion, Date: buildDate, Commit: buildCommit})
 	if err != nil {
 		panic(err)
 	}
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
+	"github.com/rutkin/url-shortener/internal/app/models"
 	"github.com/stretchr/testify/require"
 )
 
@@ -36,7 +37,7 @@ func makeRequest(t *testing.B, ts *httptest.Server, method, path string, body st
 }
 
 func BenchmarkCreateURLS(t *testing.B) {
-	server, err := NewServer()
+	server, err := NewServer(models.BuildInfo{})
 	require.NoError(t, err)
 	defer server.Close()
 
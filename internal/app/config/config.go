@@ -7,24 +7,100 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // NetAddress - type for network address
 type NetAddress string
 
+// Duration - type for durations, parsed from strings like "5m" in flags and config file
+type Duration time.Duration
+
 // Config - configuration type
 type Config struct {
 	Server          NetAddress `json:"server_address"`
 	Base            NetAddress `json:"base_url"`
 	LogLevel        string
+	StorageBackend  string `json:"storage_backend"`
 	FileStoragePath string `json:"file_storage_path"`
+	BoltStoragePath string `json:"bolt_storage_path"`
 	DatabaseDSN     string `json:"database_dsn"`
+	RedisAddress    string `json:"redis_address"`
 	EnableHTTPS     bool   `json:"enable_https"`
+	AdminAddress    string `json:"admin_address"`
 	TrustedSubnet   string `json:"trusted_subnet"`
+	// redirect cache settings, cache is disabled when size is 0;
+	// ttls of redis and postgres backends shared by several instances are capped by few seconds
+	CacheSize        int      `json:"cache_size"`
+	CacheTTL         Duration `json:"cache_ttl"`
+	CacheNegativeTTL Duration `json:"cache_negative_ttl"`
+	// storage operation timeouts, 0 means no timeout
+	StorageReadTimeout  Duration `json:"storage_read_timeout"`
+	StorageWriteTimeout Duration `json:"storage_write_timeout"`
+	// interval of redirect counters flush to storage, 0 flushes only on stats request and shutdown
+	RedirectFlushInterval Duration `json:"redirect_flush_interval"`
+	// tracing exporter: otlp, stdout, file or empty to disable tracing
+	TracingExporter string `json:"tracing_exporter"`
+	TracingEndpoint string `json:"tracing_endpoint"`
+	TracingFile     string `json:"tracing_file"`
+	// access log format: json, combined or none, file is rotated by size in megabytes
+	AccessLogFormat           string `json:"access_log_format"`
+	AccessLogFile             string `json:"access_log_file"`
+	AccessLogMaxSize          int    `json:"access_log_max_size"`
+	AccessLogMaxBackups       int    `json:"access_log_max_backups"`
+	AccessLogRedirectSampling int    `json:"access_log_redirect_sampling"`
+	// destination url validation: comma separated scheme allowlist, max length in bytes
+	// and comma separated tracking query parameters, name with trailing * matches prefix
+	AllowedSchemes string `json:"allowed_schemes"`
+	MaxURLLength   int    `json:"max_url_length"`
+	TrackingParams string `json:"tracking_params"`
+	// domain policy file with block and allow rules, reloaded when file changes, empty keeps rules in memory
+	DomainPolicyFile           string   `json:"domain_policy_file"`
+	DomainPolicyReloadInterval Duration `json:"domain_policy_reload_interval"`
+	// reputation provider: http, hashlist or empty to disable checks; stored urls are rechecked
+	// every reputation interval, 0 checks only created urls
+	ReputationProvider string   `json:"reputation_provider"`
+	ReputationEndpoint string   `json:"reputation_endpoint"`
+	ReputationHashFile string   `json:"reputation_hash_file"`
+	ReputationInterval Duration `json:"reputation_interval"`
+	ReputationTimeout  Duration `json:"reputation_timeout"`
+	// max age of permanent redirects in browser and cdn caches
+	PermanentRedirectMaxAge Duration `json:"permanent_redirect_max_age"`
+	// failed password attempts of protected url before it is locked for password lockout time
+	PasswordMaxAttempts int      `json:"password_max_attempts"`
+	PasswordLockout     Duration `json:"password_lockout"`
 }
 
 // ServerConfig - default server settings, address - http://localhost:8080, log level - info, storage - file
-var ServerConfig = Config{Server: "localhost:8080", Base: "http://localhost:8080", LogLevel: "info", FileStoragePath: "/tmp/short-url-db.json"}
+var ServerConfig = Config{
+	Server:                     "localhost:8080",
+	Base:                       "http://localhost:8080",
+	LogLevel:                   "info",
+	AdminAddress:               "localhost:8081",
+	FileStoragePath:            "/tmp/short-url-db.json",
+	BoltStoragePath:            "/tmp/short-url-db.bolt",
+	CacheSize:                  10000,
+	CacheTTL:                   Duration(5 * time.Minute),
+	CacheNegativeTTL:           Duration(30 * time.Second),
+	StorageReadTimeout:         Duration(3 * time.Second),
+	StorageWriteTimeout:        Duration(10 * time.Second),
+	RedirectFlushInterval:      Duration(10 * time.Second),
+	TracingEndpoint:            "localhost:4317",
+	TracingFile:                "/tmp/short-url-traces.json",
+	AccessLogFormat:            "json",
+	AccessLogMaxSize:           100,
+	AccessLogMaxBackups:        3,
+	AllowedSchemes:             "http,https",
+	MaxURLLength:               2048,
+	TrackingParams:             "utm_*,fbclid,gclid,dclid,gbraid,wbraid,msclkid,yclid,mc_cid,mc_eid,igshid,_ga,_gl",
+	DomainPolicyReloadInterval: Duration(10 * time.Second),
+	ReputationInterval:         Duration(time.Hour),
+	ReputationTimeout:          Duration(5 * time.Second),
+	PermanentRedirectMaxAge:    Duration(24 * time.Hour),
+	PasswordMaxAttempts:        5,
+	PasswordLockout:            Duration(time.Minute),
+}
 
 // return network address string
 func (a NetAddress) String() string {
@@ -37,6 +113,26 @@ func (a *NetAddress) Set(s string) error {
 	return nil
 }
 
+// return duration string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// set duration from string
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// unmarshal duration from json string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	return d.Set(strings.Trim(string(b), `"`))
+}
+
 // parse config from argument and environment variables
 func ParseFlags() error {
 	var configPath string
@@ -47,9 +143,40 @@ func ParseFlags() error {
 	flag.Var(&flagServerConfig.Base, "b", "base server address")
 	flag.StringVar(&flagServerConfig.LogLevel, "l", "info", "log level")
 	flag.StringVar(&flagServerConfig.FileStoragePath, "f", "/tmp/short-url-db.json", "file storage path")
-	flag.StringVar(&flagServerConfig.DatabaseDSN, "d", "", "database dsn")
+	flag.StringVar(&flagServerConfig.StorageBackend, "storage-backend", "", "storage backend: memory, file, database, redis or bolt")
+	flag.StringVar(&flagServerConfig.BoltStoragePath, "bolt-storage-path", flagServerConfig.BoltStoragePath, "bolt storage path")
+	flag.StringVar(&flagServerConfig.DatabaseDSN, "d", "", "database dsn, postgres dsn or sqlite://path")
+	flag.StringVar(&flagServerConfig.RedisAddress, "r", "", "redis server address, host:port or redis:// url")
 	flag.BoolVar(&flagServerConfig.EnableHTTPS, "s", false, "enable https")
 	flag.StringVar(&flagServerConfig.TrustedSubnet, "t", "", "trusted subnet")
+	flag.StringVar(&flagServerConfig.AdminAddress, "admin-address", flagServerConfig.AdminAddress, "admin http server address with metrics, empty disables it")
+	flag.IntVar(&flagServerConfig.CacheSize, "cache-size", flagServerConfig.CacheSize, "redirect cache size, 0 disables cache")
+	flag.Var(&flagServerConfig.CacheTTL, "cache-ttl", "redirect cache ttl")
+	flag.Var(&flagServerConfig.CacheNegativeTTL, "cache-negative-ttl", "redirect cache ttl for not found urls")
+	flag.Var(&flagServerConfig.StorageReadTimeout, "storage-read-timeout", "storage read operation timeout")
+	flag.Var(&flagServerConfig.StorageWriteTimeout, "storage-write-timeout", "storage write operation timeout")
+	flag.Var(&flagServerConfig.RedirectFlushInterval, "redirect-flush-interval", "interval of redirect counters flush to storage")
+	flag.StringVar(&flagServerConfig.TracingExporter, "tracing-exporter", "", "tracing exporter: otlp, stdout or file, empty disables tracing")
+	flag.StringVar(&flagServerConfig.TracingEndpoint, "tracing-endpoint", flagServerConfig.TracingEndpoint, "otlp grpc collector address")
+	flag.StringVar(&flagServerConfig.TracingFile, "tracing-file", flagServerConfig.TracingFile, "output path of file tracing exporter")
+	flag.StringVar(&flagServerConfig.AccessLogFormat, "access-log-format", flagServerConfig.AccessLogFormat, "access log format: json, combined or none")
+	flag.StringVar(&flagServerConfig.AccessLogFile, "access-log-file", "", "access log file, empty writes access log with service logger or stdout")
+	flag.IntVar(&flagServerConfig.AccessLogMaxSize, "access-log-max-size", flagServerConfig.AccessLogMaxSize, "access log file size in megabytes before rotation")
+	flag.IntVar(&flagServerConfig.AccessLogMaxBackups, "access-log-max-backups", flagServerConfig.AccessLogMaxBackups, "number of rotated access log files to keep")
+	flag.IntVar(&flagServerConfig.AccessLogRedirectSampling, "access-log-redirect-sampling", 1, "log only every n-th redirect response")
+	flag.StringVar(&flagServerConfig.AllowedSchemes, "allowed-schemes", flagServerConfig.AllowedSchemes, "comma separated allowed schemes of destination urls")
+	flag.IntVar(&flagServerConfig.MaxURLLength, "max-url-length", flagServerConfig.MaxURLLength, "max length of destination url, 0 disables check")
+	flag.StringVar(&flagServerConfig.TrackingParams, "tracking-params", flagServerConfig.TrackingParams, "comma separated query parameters removed from destination urls")
+	flag.StringVar(&flagServerConfig.DomainPolicyFile, "domain-policy-file", "", "file with domain block and allow rules")
+	flag.Var(&flagServerConfig.DomainPolicyReloadInterval, "domain-policy-reload-interval", "interval of domain policy file change check")
+	flag.StringVar(&flagServerConfig.ReputationProvider, "reputation-provider", "", "reputation provider: http or hashlist, empty disables checks")
+	flag.StringVar(&flagServerConfig.ReputationEndpoint, "reputation-endpoint", "", "url of http reputation provider")
+	flag.StringVar(&flagServerConfig.ReputationHashFile, "reputation-hash-file", "", "file with url hash prefixes of hashlist reputation provider")
+	flag.Var(&flagServerConfig.ReputationInterval, "reputation-interval", "interval of stored urls reputation recheck")
+	flag.Var(&flagServerConfig.ReputationTimeout, "reputation-timeout", "reputation check timeout")
+	flag.Var(&flagServerConfig.PermanentRedirectMaxAge, "permanent-redirect-max-age", "max age of permanent redirects in caches")
+	flag.IntVar(&flagServerConfig.PasswordMaxAttempts, "password-max-attempts", flagServerConfig.PasswordMaxAttempts, "failed password attempts before protected url is locked, 0 disables throttling")
+	flag.Var(&flagServerConfig.PasswordLockout, "password-lockout", "time protected url is locked after failed password attempts")
 	flag.Parse()
 
 	if len(configPath) > 0 {
@@ -82,10 +209,22 @@ func ParseFlags() error {
 		ServerConfig.FileStoragePath = fileStoragePath
 	}
 
+	if storageBackend, ok := os.LookupEnv("STORAGE_BACKEND"); ok {
+		ServerConfig.StorageBackend = storageBackend
+	}
+
+	if boltStoragePath, ok := os.LookupEnv("BOLT_STORAGE_PATH"); ok {
+		ServerConfig.BoltStoragePath = boltStoragePath
+	}
+
 	if databaseDSN, ok := os.LookupEnv("DATABASE_DSN"); ok {
 		ServerConfig.DatabaseDSN = databaseDSN
 	}
 
+	if redisAddress, ok := os.LookupEnv("REDIS_ADDRESS"); ok {
+		ServerConfig.RedisAddress = redisAddress
+	}
+
 	if enableHTTPS, ok := os.LookupEnv("ENABLE_HTTPS"); ok {
 		var err error
 		ServerConfig.EnableHTTPS, err = strconv.ParseBool(enableHTTPS)
@@ -98,5 +237,171 @@ func ParseFlags() error {
 		ServerConfig.TrustedSubnet = trustedSubnet
 	}
 
+	if adminAddress, ok := os.LookupEnv("ADMIN_ADDRESS"); ok {
+		ServerConfig.AdminAddress = adminAddress
+	}
+
+	if cacheSize, ok := os.LookupEnv("CACHE_SIZE"); ok {
+		var err error
+		ServerConfig.CacheSize, err = strconv.Atoi(cacheSize)
+		if err != nil {
+			return fmt.Errorf("failed to parse cache size int value from '%s'", cacheSize)
+		}
+	}
+
+	if cacheTTL, ok := os.LookupEnv("CACHE_TTL"); ok {
+		err := ServerConfig.CacheTTL.Set(cacheTTL)
+		if err != nil {
+			return fmt.Errorf("failed to parse cache ttl duration value from '%s'", cacheTTL)
+		}
+	}
+
+	if cacheNegativeTTL, ok := os.LookupEnv("CACHE_NEGATIVE_TTL"); ok {
+		err := ServerConfig.CacheNegativeTTL.Set(cacheNegativeTTL)
+		if err != nil {
+			return fmt.Errorf("failed to parse cache negative ttl duration value from '%s'", cacheNegativeTTL)
+		}
+	}
+
+	if storageReadTimeout, ok := os.LookupEnv("STORAGE_READ_TIMEOUT"); ok {
+		err := ServerConfig.StorageReadTimeout.Set(storageReadTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse storage read timeout duration value from '%s'", storageReadTimeout)
+		}
+	}
+
+	if storageWriteTimeout, ok := os.LookupEnv("STORAGE_WRITE_TIMEOUT"); ok {
+		err := ServerConfig.StorageWriteTimeout.Set(storageWriteTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse storage write timeout duration value from '%s'", storageWriteTimeout)
+		}
+	}
+
+	if redirectFlushInterval, ok := os.LookupEnv("REDIRECT_FLUSH_INTERVAL"); ok {
+		err := ServerConfig.RedirectFlushInterval.Set(redirectFlushInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse redirect flush interval duration value from '%s'", redirectFlushInterval)
+		}
+	}
+
+	if tracingExporter, ok := os.LookupEnv("TRACING_EXPORTER"); ok {
+		ServerConfig.TracingExporter = tracingExporter
+	}
+
+	if tracingEndpoint, ok := os.LookupEnv("TRACING_ENDPOINT"); ok {
+		ServerConfig.TracingEndpoint = tracingEndpoint
+	}
+
+	if tracingFile, ok := os.LookupEnv("TRACING_FILE"); ok {
+		ServerConfig.TracingFile = tracingFile
+	}
+
+	if accessLogFormat, ok := os.LookupEnv("ACCESS_LOG_FORMAT"); ok {
+		ServerConfig.AccessLogFormat = accessLogFormat
+	}
+
+	if accessLogFile, ok := os.LookupEnv("ACCESS_LOG_FILE"); ok {
+		ServerConfig.AccessLogFile = accessLogFile
+	}
+
+	if accessLogMaxSize, ok := os.LookupEnv("ACCESS_LOG_MAX_SIZE"); ok {
+		var err error
+		ServerConfig.AccessLogMaxSize, err = strconv.Atoi(accessLogMaxSize)
+		if err != nil {
+			return fmt.Errorf("failed to parse access log max size int value from '%s'", accessLogMaxSize)
+		}
+	}
+
+	if accessLogMaxBackups, ok := os.LookupEnv("ACCESS_LOG_MAX_BACKUPS"); ok {
+		var err error
+		ServerConfig.AccessLogMaxBackups, err = strconv.Atoi(accessLogMaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to parse access log max backups int value from '%s'", accessLogMaxBackups)
+		}
+	}
+
+	if accessLogRedirectSampling, ok := os.LookupEnv("ACCESS_LOG_REDIRECT_SAMPLING"); ok {
+		var err error
+		ServerConfig.AccessLogRedirectSampling, err = strconv.Atoi(accessLogRedirectSampling)
+		if err != nil {
+			return fmt.Errorf("failed to parse access log redirect sampling int value from '%s'", accessLogRedirectSampling)
+		}
+	}
+
+	if allowedSchemes, ok := os.LookupEnv("ALLOWED_SCHEMES"); ok {
+		ServerConfig.AllowedSchemes = allowedSchemes
+	}
+
+	if maxURLLength, ok := os.LookupEnv("MAX_URL_LENGTH"); ok {
+		var err error
+		ServerConfig.MaxURLLength, err = strconv.Atoi(maxURLLength)
+		if err != nil {
+			return fmt.Errorf("failed to parse max url length int value from '%s'", maxURLLength)
+		}
+	}
+
+	if trackingParams, ok := os.LookupEnv("TRACKING_PARAMS"); ok {
+		ServerConfig.TrackingParams = trackingParams
+	}
+
+	if domainPolicyFile, ok := os.LookupEnv("DOMAIN_POLICY_FILE"); ok {
+		ServerConfig.DomainPolicyFile = domainPolicyFile
+	}
+
+	if domainPolicyReloadInterval, ok := os.LookupEnv("DOMAIN_POLICY_RELOAD_INTERVAL"); ok {
+		err := ServerConfig.DomainPolicyReloadInterval.Set(domainPolicyReloadInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse domain policy reload interval duration value from '%s'", domainPolicyReloadInterval)
+		}
+	}
+
+	if reputationProvider, ok := os.LookupEnv("REPUTATION_PROVIDER"); ok {
+		ServerConfig.ReputationProvider = reputationProvider
+	}
+
+	if reputationEndpoint, ok := os.LookupEnv("REPUTATION_ENDPOINT"); ok {
+		ServerConfig.ReputationEndpoint = reputationEndpoint
+	}
+
+	if reputationHashFile, ok := os.LookupEnv("REPUTATION_HASH_FILE"); ok {
+		ServerConfig.ReputationHashFile = reputationHashFile
+	}
+
+	if reputationInterval, ok := os.LookupEnv("REPUTATION_INTERVAL"); ok {
+		err := ServerConfig.ReputationInterval.Set(reputationInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse reputation interval duration value from '%s'", reputationInterval)
+		}
+	}
+
+	if reputationTimeout, ok := os.LookupEnv("REPUTATION_TIMEOUT"); ok {
+		err := ServerConfig.ReputationTimeout.Set(reputationTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse reputation timeout duration value from '%s'", reputationTimeout)
+		}
+	}
+
+	if maxAge, ok := os.LookupEnv("PERMANENT_REDIRECT_MAX_AGE"); ok {
+		err := ServerConfig.PermanentRedirectMaxAge.Set(maxAge)
+		if err != nil {
+			return fmt.Errorf("failed to parse permanent redirect max age duration value from '%s'", maxAge)
+		}
+	}
+
+	if maxAttempts, ok := os.LookupEnv("PASSWORD_MAX_ATTEMPTS"); ok {
+		var err error
+		ServerConfig.PasswordMaxAttempts, err = strconv.Atoi(maxAttempts)
+		if err != nil {
+			return fmt.Errorf("failed to parse password max attempts int value from '%s'", maxAttempts)
+		}
+	}
+
+	if lockout, ok := os.LookupEnv("PASSWORD_LOCKOUT"); ok {
+		err := ServerConfig.PasswordLockout.Set(lockout)
+		if err != nil {
+			return fmt.Errorf("failed to parse password lockout duration value from '%s'", lockout)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/policy"
+	"github.com/rutkin/url-shortener/internal/app/service"
+	"go.uber.org/zap"
+)
+
+// create new instance of admin handler
+func NewAdminHandler(s service.Service, build models.BuildInfo) *AdminHandler {
+	return &AdminHandler{s, build}
+}
+
+// admin handler type, serves probes and build info
+type AdminHandler struct {
+	service service.Service
+	build   models.BuildInfo
+}
+
+// liveness probe, process is able to serve requests
+func (h AdminHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// readiness probe, storage backend is reachable
+func (h AdminHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.Ready(r.Context()); err != nil {
+		logger.FromContext(r.Context()).Error("storage is not ready", zap.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// build info of running binary
+func (h AdminHandler) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.build); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+	}
+}
+
+// rules of domain policy
+func (h AdminHandler) DomainRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.service.DomainRules()); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+	}
+}
+
+// decode rule from body and apply change to domain policy
+func (h AdminHandler) changeDomainRule(w http.ResponseWriter, r *http.Request, change func(policy.Rule) error, status int) {
+	var rule policy.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err := change(rule)
+	switch {
+	case errors.Is(err, policy.ErrInvalidRule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, policy.ErrRuleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		logger.FromContext(r.Context()).Error("failed to change domain policy", zap.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		logger.FromContext(r.Context()).Info("domain policy changed",
+			zap.String("method", r.Method),
+			zap.String("rule", rule.String()))
+		w.WriteHeader(status)
+	}
+}
+
+// add rule to domain policy
+func (h AdminHandler) AddDomainRule(w http.ResponseWriter, r *http.Request) {
+	h.changeDomainRule(w, r, h.service.AddDomainRule, http.StatusCreated)
+}
+
+// remove rule from domain policy
+func (h AdminHandler) RemoveDomainRule(w http.ResponseWriter, r *http.Request) {
+	h.changeDomainRule(w, r, h.service.RemoveDomainRule, http.StatusNoContent)
+}
+
+// disable stored urls of domains which are blocked by current policy
+func (h AdminHandler) DisableBlockedURLS(w http.ResponseWriter, r *http.Request) {
+	disabled, err := h.service.DisableBlockedURLS(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"disabled": disabled}); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+	}
+}
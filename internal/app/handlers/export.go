@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"go.uber.org/zap"
+)
+
+var errUnsupportedFormat = errors.New("unsupported format")
+
+// formats of exported urls
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// rows written between flushes of streamed export
+const exportFlushRows = 100
+
+// columns of exported csv rows
+var exportCSVHeader = []string{"short_url", "original_url", "title", "tags", "notes", "deleted"}
+
+// writer of exported urls
+type urlWriter interface {
+	Write(url models.URLRecord) error
+	Flush() error
+}
+
+// csv rows with header, tags are joined with comma
+type csvURLWriter struct {
+	writer *csv.Writer
+}
+
+func (w csvURLWriter) Write(url models.URLRecord) error {
+	return w.writer.Write([]string{url.ShortURL, url.OriginalURL, url.Title,
+		strings.Join(url.Tags, ","), url.Notes, strconv.FormatBool(url.Deleted)})
+}
+
+func (w csvURLWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// json object per line
+type jsonlURLWriter struct {
+	encoder *json.Encoder
+}
+
+func (w jsonlURLWriter) Write(url models.URLRecord) error {
+	return w.encoder.Encode(url)
+}
+
+func (w jsonlURLWriter) Flush() error {
+	return nil
+}
+
+// stream user urls as csv or jsonl file from format query parameter,
+// urls are filtered like user urls list and written while they are read from storage
+func (h URLHandler) ExportURLS(w http.ResponseWriter, r *http.Request) error {
+	userID, err := h.getUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	var contentType string
+	var writer urlWriter
+	switch format {
+	case formatCSV:
+		contentType = "text/csv; charset=utf-8"
+		writer = csvURLWriter{csv.NewWriter(w)}
+	case formatJSONL:
+		contentType = "application/x-ndjson"
+		writer = jsonlURLWriter{json.NewEncoder(w)}
+	default:
+		return fmt.Errorf("%w '%s'", errUnsupportedFormat, format)
+	}
+	filter, err := parseURLFilter(query)
+	if err != nil {
+		return err
+	}
+
+	// response starts with first url, so errors before it still get error status
+	rows := 0
+	start := func() error {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+		if csvWriter, ok := writer.(csvURLWriter); ok {
+			return csvWriter.writer.Write(exportCSVHeader)
+		}
+		return nil
+	}
+	err = h.service.ExportURLS(r.Context(), userID, filter, func(url models.URLRecord) error {
+		if rows == 0 {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		url.ShortURL = h.createResponseAddress(url.ShortURL)
+		if err := writer.Write(url); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			http.NewResponseController(w).Flush()
+		}
+		return nil
+	})
+	if err != nil && rows == 0 {
+		logger.FromContext(r.Context()).Error("failed to export urls", zap.String("error", err.Error()))
+		return err
+	}
+	if err != nil {
+		// status is already sent, client gets truncated file
+		logger.FromContext(r.Context()).Error("failed to export urls",
+			zap.Int("rows", rows),
+			zap.String("error", err.Error()))
+		return nil
+	}
+
+	if rows == 0 {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		logger.FromContext(r.Context()).Error("failed to write export", zap.String("error", err.Error()))
+	}
+	return nil
+}
@@ -2,10 +2,10 @@ package handlers
 
 import (
 	context "context"
+	"fmt"
 
-	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
 	"github.com/rutkin/url-shortener/internal/app/service"
-	"go.uber.org/zap"
 )
 
 type GRPCHanlder struct {
@@ -13,19 +13,20 @@ type GRPCHanlder struct {
 	service service.Service
 }
 
-func NewGRPCHandler() (*GRPCHanlder, error) {
-	s, err := service.NewURLService()
-	if err != nil {
-		logger.Log.Error("failed to create url service", zap.String("error", err.Error()))
-		return nil, err
-	}
-
-	return &GRPCHanlder{service: s}, nil
+func NewGRPCHandler(s service.Service) *GRPCHanlder {
+	return &GRPCHanlder{service: s}
 }
 
 func (grpc *GRPCHanlder) CreateURL(ctx context.Context, in *CreateURLRequest) (*CreateURLResponse, error) {
 	var result CreateURLResponse
-	shortURL, err := grpc.service.CreateURL([]byte(in.LongUrl), in.UserId)
+	shortURL, err := grpc.service.CreateURL(ctx, []byte(in.LongUrl), in.UserId, models.URLOptions{
+		RedirectType: int(in.RedirectType),
+		Password:     in.Password,
+		MaxClicks:    int(in.MaxClicks),
+		Title:        in.Title,
+		Tags:         in.Tags,
+		Notes:        in.Notes,
+	})
 	if err != nil {
 		result.Error = err.Error()
 	} else {
@@ -36,7 +37,7 @@ func (grpc *GRPCHanlder) CreateURL(ctx context.Context, in *CreateURLRequest) (*
 
 func (grpc *GRPCHanlder) CreateURLS(ctx context.Context, in *CreateURLSRequest) (*CreateURLSResponse, error) {
 	var result CreateURLSResponse
-	resp, err := grpc.service.CreateURLS(in.LongUrl, in.UserId)
+	resp, err := grpc.service.CreateURLS(ctx, in.LongUrl, in.UserId)
 	if err != nil {
 		result.Error = err.Error()
 	} else {
@@ -47,32 +48,72 @@ func (grpc *GRPCHanlder) CreateURLS(ctx context.Context, in *CreateURLSRequest)
 
 func (grpc *GRPCHanlder) GetURL(ctx context.Context, in *GetURLRequest) (*GetURLResponse, error) {
 	var result GetURLResponse
-	resp, err := grpc.service.GetURL(in.ShortUrl)
+	resp, err := grpc.service.GetURL(ctx, in.ShortUrl)
+	if err == nil && resp.PasswordHash != "" {
+		resp, err = grpc.service.UnlockURL(ctx, in.ShortUrl, in.Password)
+	}
 	if err != nil {
 		result.Error = err.Error()
+	} else if resp.Threat != "" {
+		result.Error = fmt.Sprintf("%s: %s", errURLFlagged.Error(), resp.Threat)
 	} else {
-		result.LongUrl = resp
+		result.LongUrl = resp.URL
+		result.RedirectType = int32(resp.RedirectType)
 	}
 	return &result, nil
 }
 
 func (grpc *GRPCHanlder) DeleteURLS(ctx context.Context, in *DeleteURLSRequest) (*DeleteURLSResponse, error) {
 	var result DeleteURLSResponse
-	err := grpc.service.DeleteURLS(in.ShortUrl, in.UserId)
+	err := grpc.service.DeleteURLS(ctx, in.ShortUrl, in.UserId)
 	if err != nil {
 		result.Error = err.Error()
 	}
 	return &result, nil
 }
 
-func (grpc *GRPCHanlder) GetStats(context.Context, *Empty) (*GetStatsResponse, error) {
+func (grpc *GRPCHanlder) UpdateURL(ctx context.Context, in *UpdateURLRequest) (*UpdateURLResponse, error) {
+	var result UpdateURLResponse
+	longURL, err := grpc.service.UpdateURL(ctx, in.ShortUrl, in.UserId, in.LongUrl)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.LongUrl = longURL
+	}
+	return &result, nil
+}
+
+// stream user urls, failed export ends stream with error
+func (grpc *GRPCHanlder) ExportURLS(in *ExportURLSRequest, stream GRPCHandler_ExportURLSServer) error {
+	filter := models.URLFilter{Tag: in.Tag, Search: in.Search, IncludeDeleted: in.IncludeDeleted, Order: in.Order}
+	return grpc.service.ExportURLS(stream.Context(), in.UserId, filter, func(url models.URLRecord) error {
+		return stream.Send(&ExportedURL{
+			ShortUrl:    url.ShortURL,
+			OriginalUrl: url.OriginalURL,
+			Title:       url.Title,
+			Tags:        url.Tags,
+			Notes:       url.Notes,
+			Deleted:     url.Deleted,
+		})
+	})
+}
+
+func (grpc *GRPCHanlder) GetStats(ctx context.Context, in *Empty) (*GetStatsResponse, error) {
 	var result GetStatsResponse
-	resp, err := grpc.service.GetStats()
+	resp, err := grpc.service.GetStats(ctx)
 	if err != nil {
 		result.Error = err.Error()
 	} else {
 		result.Urls = int64(resp.URLS)
 		result.Users = int64(resp.Users)
+		result.ActiveUrls = int64(resp.ActiveURLS)
+		result.DeletedUrls = int64(resp.DeletedURLS)
+		result.CreatedLastDay = int64(resp.CreatedLastDay)
+		result.CreatedLastWeek = int64(resp.CreatedLastWeek)
+		result.Redirects = resp.Redirects
+		for _, domain := range resp.TopDomains {
+			result.TopDomains = append(result.TopDomains, &DomainStat{Domain: domain.Domain, Urls: int64(domain.URLS)})
+		}
 	}
 	return &result, nil
 }
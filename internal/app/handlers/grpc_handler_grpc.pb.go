@@ -23,6 +23,8 @@ const (
 	GRPCHandler_CreateURLS_FullMethodName = "/handlers.GRPCHandler/CreateURLS"
 	GRPCHandler_GetURL_FullMethodName     = "/handlers.GRPCHandler/GetURL"
 	GRPCHandler_DeleteURLS_FullMethodName = "/handlers.GRPCHandler/DeleteURLS"
+	GRPCHandler_UpdateURL_FullMethodName  = "/handlers.GRPCHandler/UpdateURL"
+	GRPCHandler_ExportURLS_FullMethodName = "/handlers.GRPCHandler/ExportURLS"
 	GRPCHandler_GetStats_FullMethodName   = "/handlers.GRPCHandler/GetStats"
 )
 
@@ -34,6 +36,8 @@ type GRPCHandlerClient interface {
 	CreateURLS(ctx context.Context, in *CreateURLSRequest, opts ...grpc.CallOption) (*CreateURLSResponse, error)
 	GetURL(ctx context.Context, in *GetURLRequest, opts ...grpc.CallOption) (*GetURLResponse, error)
 	DeleteURLS(ctx context.Context, in *DeleteURLSRequest, opts ...grpc.CallOption) (*DeleteURLSResponse, error)
+	UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*UpdateURLResponse, error)
+	ExportURLS(ctx context.Context, in *ExportURLSRequest, opts ...grpc.CallOption) (GRPCHandler_ExportURLSClient, error)
 	GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GetStatsResponse, error)
 }
 
@@ -85,6 +89,49 @@ func (c *gRPCHandlerClient) DeleteURLS(ctx context.Context, in *DeleteURLSReques
 	return out, nil
 }
 
+func (c *gRPCHandlerClient) UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*UpdateURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateURLResponse)
+	err := c.cc.Invoke(ctx, GRPCHandler_UpdateURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gRPCHandlerClient) ExportURLS(ctx context.Context, in *ExportURLSRequest, opts ...grpc.CallOption) (GRPCHandler_ExportURLSClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GRPCHandler_ServiceDesc.Streams[0], GRPCHandler_ExportURLS_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gRPCHandlerExportURLSClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GRPCHandler_ExportURLSClient interface {
+	Recv() (*ExportedURL, error)
+	grpc.ClientStream
+}
+
+type gRPCHandlerExportURLSClient struct {
+	grpc.ClientStream
+}
+
+func (x *gRPCHandlerExportURLSClient) Recv() (*ExportedURL, error) {
+	m := new(ExportedURL)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *gRPCHandlerClient) GetStats(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*GetStatsResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GetStatsResponse)
@@ -103,6 +150,8 @@ type GRPCHandlerServer interface {
 	CreateURLS(context.Context, *CreateURLSRequest) (*CreateURLSResponse, error)
 	GetURL(context.Context, *GetURLRequest) (*GetURLResponse, error)
 	DeleteURLS(context.Context, *DeleteURLSRequest) (*DeleteURLSResponse, error)
+	UpdateURL(context.Context, *UpdateURLRequest) (*UpdateURLResponse, error)
+	ExportURLS(*ExportURLSRequest, GRPCHandler_ExportURLSServer) error
 	GetStats(context.Context, *Empty) (*GetStatsResponse, error)
 	mustEmbedUnimplementedGRPCHandlerServer()
 }
@@ -123,6 +172,12 @@ func (UnimplementedGRPCHandlerServer) GetURL(context.Context, *GetURLRequest) (*
 func (UnimplementedGRPCHandlerServer) DeleteURLS(context.Context, *DeleteURLSRequest) (*DeleteURLSResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteURLS not implemented")
 }
+func (UnimplementedGRPCHandlerServer) UpdateURL(context.Context, *UpdateURLRequest) (*UpdateURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateURL not implemented")
+}
+func (UnimplementedGRPCHandlerServer) ExportURLS(*ExportURLSRequest, GRPCHandler_ExportURLSServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportURLS not implemented")
+}
 func (UnimplementedGRPCHandlerServer) GetStats(context.Context, *Empty) (*GetStatsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
 }
@@ -211,6 +266,45 @@ func _GRPCHandler_DeleteURLS_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _GRPCHandler_UpdateURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GRPCHandlerServer).UpdateURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GRPCHandler_UpdateURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GRPCHandlerServer).UpdateURL(ctx, req.(*UpdateURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GRPCHandler_ExportURLS_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportURLSRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GRPCHandlerServer).ExportURLS(m, &gRPCHandlerExportURLSServer{ServerStream: stream})
+}
+
+type GRPCHandler_ExportURLSServer interface {
+	Send(*ExportedURL) error
+	grpc.ServerStream
+}
+
+type gRPCHandlerExportURLSServer struct {
+	grpc.ServerStream
+}
+
+func (x *gRPCHandlerExportURLSServer) Send(m *ExportedURL) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _GRPCHandler_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Empty)
 	if err := dec(in); err != nil {
@@ -252,11 +346,21 @@ var GRPCHandler_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteURLS",
 			Handler:    _GRPCHandler_DeleteURLS_Handler,
 		},
+		{
+			MethodName: "UpdateURL",
+			Handler:    _GRPCHandler_UpdateURL_Handler,
+		},
 		{
 			MethodName: "GetStats",
 			Handler:    _GRPCHandler_GetStats_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportURLS",
+			Handler:       _GRPCHandler_ExportURLS_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "internal/app/handlers/grpc_handler.proto",
 }
@@ -4,19 +4,40 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/policy"
 	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/service"
+	"go.uber.org/zap"
 )
 
-// wrapper function convert error to http error status
+// wrapper function convert error to http error status, route pattern is added to request logger
 func NewHandler(fn func(http.ResponseWriter, *http.Request) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			r = r.WithContext(logger.WithFields(r.Context(), zap.String("route", rctx.RoutePattern())))
+		}
 		err := fn(w, r)
 		if errors.Is(err, repository.ErrConflict) {
 			w.WriteHeader(http.StatusConflict)
 			return
-		} else if errors.Is(err, repository.ErrURLDeleted) {
+		} else if errors.Is(err, repository.ErrURLDeleted) || errors.Is(err, repository.ErrURLExhausted) {
 			w.WriteHeader(http.StatusGone)
 			return
+		} else if errors.Is(err, service.ErrRevisionNotFound) || errors.Is(err, errUserURLNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, policy.ErrDomainBlocked) || errors.Is(err, repository.ErrNotOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if errors.Is(err, service.ErrInvalidPassword) || errors.Is(err, service.ErrPasswordRequired) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		} else if errors.Is(err, service.ErrTooManyAttempts) {
+			setRetryAfter(w)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
 		} else if errors.Is(err, errForbidden) {
 			w.WriteHeader(http.StatusForbidden)
 		}
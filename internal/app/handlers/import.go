@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"go.uber.org/zap"
+)
+
+var errMissingColumn = errors.New("missing column")
+
+// rows imported with one batch insert, batch with conflict reads short url of every row to find conflicting ones
+const importBatchSize = 500
+
+// max length of imported jsonl line
+const maxImportLineSize = 1 << 20
+
+// error of single imported row, next rows are still read
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string {
+	return e.err.Error()
+}
+
+func (e rowError) Unwrap() error {
+	return e.err
+}
+
+// reader of imported urls, io.EOF ends rows
+type urlReader interface {
+	Read() (models.URLRecord, error)
+}
+
+// csv rows with header in export format, only original_url column is required
+type csvURLReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVURLReader(r io.Reader) (*csvURLReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w original_url", errMissingColumn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, fmt.Errorf("%w original_url", errMissingColumn)
+	}
+	return &csvURLReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvURLReader) Read() (models.URLRecord, error) {
+	fields, err := r.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return models.URLRecord{}, rowError{err}
+	}
+	if err != nil {
+		return models.URLRecord{}, err
+	}
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+
+	url := models.URLRecord{ShortURL: field("short_url"), OriginalURL: field("original_url"), Title: field("title"), Notes: field("notes")}
+	if tags := field("tags"); tags != "" {
+		url.Tags = strings.Split(tags, ",")
+	}
+	if deleted := field("deleted"); deleted != "" {
+		if url.Deleted, err = strconv.ParseBool(deleted); err != nil {
+			return models.URLRecord{}, rowError{err}
+		}
+	}
+	return url, nil
+}
+
+// json object per line in export format, blank lines are skipped
+type jsonlURLReader struct {
+	scanner *bufio.Scanner
+}
+
+func newJSONLURLReader(r io.Reader) *jsonlURLReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxImportLineSize)
+	return &jsonlURLReader{scanner: scanner}
+}
+
+func (r *jsonlURLReader) Read() (models.URLRecord, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var url models.URLRecord
+		if err := json.Unmarshal(line, &url); err != nil {
+			return models.URLRecord{}, rowError{err}
+		}
+		return url, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return models.URLRecord{}, err
+	}
+	return models.URLRecord{}, io.EOF
+}
+
+// format of imported file from format query parameter or content type
+func importFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl":
+		return formatJSONL
+	case "text/csv", "":
+		return formatCSV
+	}
+	return mediaType
+}
+
+// import user urls from csv or jsonl file in export format, short urls are kept as aliases;
+// body is read in batches and result of every row is written as json line while next rows are read
+func (h URLHandler) ImportURLS(w http.ResponseWriter, r *http.Request) error {
+	userID, err := h.getUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	var reader urlReader
+	switch format := importFormat(r); format {
+	case formatCSV:
+		if reader, err = newCSVURLReader(r.Body); err != nil {
+			return err
+		}
+	case formatJSONL:
+		reader = newJSONLURLReader(r.Body)
+	default:
+		return fmt.Errorf("%w '%s'", errUnsupportedFormat, format)
+	}
+
+	// http/1.x body is read after response is started
+	http.NewResponseController(w).EnableFullDuplex()
+
+	row := 0      // rows read
+	reported := 0 // rows with written result
+	encoder := json.NewEncoder(w)
+	write := func(result models.ImportResult) error {
+		if reported == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		reported = result.Row
+		return encoder.Encode(result)
+	}
+
+	var batch []models.URLRecord
+	importBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := h.service.ImportURLS(r.Context(), userID, batch)
+		if err != nil {
+			return err
+		}
+		first := row - len(batch) + 1
+		batch = batch[:0]
+		for i, result := range results {
+			result.Row = first + i
+			if result.Status == models.ImportCreated || result.Status == models.ImportConflict {
+				result.ShortURL = h.createResponseAddress(result.ShortURL)
+			}
+			if err := write(result); err != nil {
+				return err
+			}
+		}
+		http.NewResponseController(w).Flush()
+		return nil
+	}
+
+	for err == nil {
+		var url models.URLRecord
+		url, err = reader.Read()
+		var invalidRow rowError
+		switch {
+		case err == nil:
+			row++
+			batch = append(batch, url)
+			if len(batch) == importBatchSize {
+				err = importBatch()
+			}
+		case errors.As(err, &invalidRow):
+			// rows before invalid one are imported first to keep results in order
+			if err = importBatch(); err == nil {
+				row++
+				err = write(models.ImportResult{Row: row, Status: models.ImportFailed, Error: invalidRow.Error()})
+			}
+		case err == io.EOF:
+			err = importBatch()
+			if err == nil {
+				return nil
+			}
+		}
+	}
+
+	logger.FromContext(r.Context()).Error("failed to import urls",
+		zap.Int("row", row),
+		zap.String("error", err.Error()))
+	if reported == 0 {
+		return err
+	}
+	// status is already sent, rows after last reported one are not imported
+	write(models.ImportResult{Row: reported + 1, Status: models.ImportFailed, Error: err.Error()})
+	return nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+)
+
+var errURLFlagged = errors.New("url is flagged by reputation provider")
+
+// warning page shown instead of redirect to url flagged by reputation provider
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Warning: unsafe link</title>
+</head>
+<body>
+<h1>Warning: this link may be unsafe</h1>
+<p>The destination of this short link was reported as <strong>{{.Threat}}</strong>.
+Visiting it may harm your device or steal your personal information.</p>
+<p>Destination: <code>{{.URL}}</code></p>
+<p><a href="{{.URL}}" rel="noopener noreferrer nofollow">Continue to the site anyway</a></p>
+</body>
+</html>
+`))
+
+// preview page of short url destination
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title>
+</head>
+<body>
+<h1>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</h1>
+<p>Short link <code>{{.ShortURL}}</code> leads to:</p>
+<p><code>{{.OriginalURL}}</code></p>
+{{if not .CreatedAt.IsZero}}<p>Created on {{.CreatedAt.Format "2006-01-02"}}</p>
+{{end}}<p><a href="{{.OriginalURL}}" rel="noopener noreferrer">Continue to the site</a></p>
+</body>
+</html>
+`))
+
+// password form of protected url
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Protected link</title>
+</head>
+<body>
+<h1>This link is protected</h1>
+<p>Enter the password to continue.</p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>
+{{end}}<form method="post" action="{{.Action}}">
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// write password form posted to unlock url, message describes previous failed attempt
+func writePasswordForm(w http.ResponseWriter, action string, message string, status int) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return passwordTemplate.Execute(w, struct{ Action, Error string }{action, message})
+}
+
+// write warning page about flagged url, page is not cached because flag can be cleared
+func writeInterstitial(w http.ResponseWriter, record repository.URLRecord) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	return interstitialTemplate.Execute(w, record)
+}
+
+// write preview of url in json when it is accepted, otherwise in html;
+// html preview of flagged url is replaced with warning page
+func writePreview(w http.ResponseWriter, r *http.Request, shortURL string, record repository.URLRecord) error {
+	preview := models.URLPreview{
+		ShortURL:    shortURL,
+		OriginalURL: record.URL,
+		Title:       record.Title,
+		CreatedAt:   record.CreatedAt,
+		Threat:      record.Threat,
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(preview)
+	}
+	if record.Threat != "" {
+		return writeInterstitial(w, record)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return previewTemplate.Execute(w, preview)
+}
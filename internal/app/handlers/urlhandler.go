@@ -2,11 +2,17 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rutkin/url-shortener/internal/app/config"
@@ -21,15 +27,18 @@ var errUnsupportedBody = errors.New("unsupported body")
 var errInvalidContext = errors.New("invalid context")
 var errAccessDenied = errors.New("access denied")
 var errForbidden = errors.New("forbidden")
+var errUnsupportedBucket = errors.New("unsupported bucket")
+var errInvalidRange = errors.New("invalid range")
+
+// errUserURLNotFound - user url addressed by id doesn't exist, unlike unknown short url of redirect
+var errUserURLNotFound = errors.New("user url not found")
 var maxBodySize = int64(2000)
 
+// header with password of protected url
+const passwordHeader = "X-Link-Password"
+
 // create new instance of url handler
-func NewURLHandler() (*URLHandler, error) {
-	s, err := service.NewURLService()
-	if err != nil {
-		logger.Log.Error("failed to create url service", zap.String("error", err.Error()))
-		return nil, err
-	}
+func NewURLHandler(s service.Service) (*URLHandler, error) {
 	_, trustedSubnet, err := net.ParseCIDR(config.ServerConfig.TrustedSubnet)
 	if err != nil {
 		logger.Log.Error("failed to parsed trusted subnet", zap.String("error", err.Error()))
@@ -78,18 +87,13 @@ func (h URLHandler) writeURLBodyInJSON(w http.ResponseWriter, shortURL string, s
 func (h URLHandler) getUserID(context context.Context) (string, error) {
 	userID := context.Value(service.UserIDKey)
 	if userID == nil {
-		logger.Log.Error("userID value does not exists in context")
+		logger.FromContext(context).Error("userID value does not exists in context")
 		return "", errInvalidContext
 	}
 
 	return userID.(string), nil
 }
 
-// must to call after create, to avoid memory leak
-func (h URLHandler) Close() error {
-	return h.service.Close()
-}
-
 // create short url with text body
 func (h URLHandler) CreateURLWithTextBody(w http.ResponseWriter, r *http.Request) error {
 	limitedBody := http.MaxBytesReader(w, r.Body, maxBodySize)
@@ -97,7 +101,7 @@ func (h URLHandler) CreateURLWithTextBody(w http.ResponseWriter, r *http.Request
 	defer limitedBody.Close()
 
 	if err != nil {
-		logger.Log.Error("failed to read request body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to read request body", zap.String("error", err.Error()))
 		return err
 	}
 
@@ -107,7 +111,7 @@ func (h URLHandler) CreateURLWithTextBody(w http.ResponseWriter, r *http.Request
 	}
 
 	var id string
-	id, err = h.service.CreateURL(urlBytes, userID)
+	id, err = h.service.CreateURL(r.Context(), urlBytes, userID, models.URLOptions{})
 
 	if errors.Is(err, repository.ErrConflict) {
 		writeErr := h.writeURLBodyInText(w, id, http.StatusConflict)
@@ -118,35 +122,131 @@ func (h URLHandler) CreateURLWithTextBody(w http.ResponseWriter, r *http.Request
 	}
 
 	if err != nil {
-		logger.Log.Error("failed create url from request body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed create url from request body", zap.String("error", err.Error()))
 		return err
 	}
 
 	return h.writeURLBodyInText(w, id, http.StatusCreated)
 }
 
-// get url by short id
+// get url by short id, preview page is shown for id with + suffix or preview=1 query
+// and instead of redirect for preview links
 func (h URLHandler) GetURL(w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
+	if previewID, ok := strings.CutSuffix(id, "+"); ok || r.URL.Query().Get("preview") == "1" {
+		return h.previewURL(w, r, previewID)
+	}
+
+	record, err := h.service.GetURL(r.Context(), id)
+
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to get url by id", zap.String("error", err.Error()))
+		return err
+	}
+
+	if record.PasswordHash != "" {
+		password := r.Header.Get(passwordHeader)
+		if password == "" {
+			return writePasswordForm(w, h.unlockAddress(id), "", http.StatusUnauthorized)
+		}
+		record, err = h.service.UnlockURL(r.Context(), id, password)
+		if err != nil {
+			return err
+		}
+	}
+	if record.Threat != "" {
+		return writeInterstitial(w, record)
+	}
+	if record.Preview {
+		return writePreview(w, r, h.createResponseAddress(id), record)
+	}
+
+	status := record.RedirectType
+	if status == 0 {
+		status = http.StatusTemporaryRedirect
+	}
+	w.Header().Set("Cache-Control", redirectCacheControl(status))
+	w.Header().Add("Location", record.URL)
+	w.WriteHeader(status)
 
-	url, err := h.service.GetURL(id)
+	return nil
+}
+
+// permanent redirects are cached by browsers and cdn for configured time,
+// temporary redirects are revalidated so every redirect is counted
+func redirectCacheControl(status int) string {
+	maxAge := time.Duration(config.ServerConfig.PermanentRedirectMaxAge)
+	if (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) && maxAge > 0 {
+		return "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+	return "private, no-cache"
+}
 
+// show preview of url destination without redirect
+func (h URLHandler) previewURL(w http.ResponseWriter, r *http.Request, id string) error {
+	record, err := h.service.PreviewURL(r.Context(), id)
 	if err != nil {
-		logger.Log.Error("failed to get url by id", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to get url preview by id", zap.String("error", err.Error()))
 		return err
 	}
+	if record.PasswordHash != "" {
+		return writePasswordForm(w, h.unlockAddress(id), "", http.StatusUnauthorized)
+	}
+	return writePreview(w, r, h.createResponseAddress(id), record)
+}
 
-	w.Header().Add("Location", url)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+func (h URLHandler) unlockAddress(id string) string {
+	return h.createResponseAddress(id) + "/unlock"
+}
+
+// check password of protected url posted from password form or in header and redirect to url,
+// failed attempt shows password form again
+func (h URLHandler) UnlockURL(w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	password := r.Header.Get(passwordHeader)
+	if password == "" {
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		password = r.PostForm.Get("password")
+	}
+
+	record, err := h.service.UnlockURL(r.Context(), id, password)
+	switch {
+	case errors.Is(err, service.ErrPasswordRequired):
+		return writePasswordForm(w, h.unlockAddress(id), "", http.StatusUnauthorized)
+	case errors.Is(err, service.ErrInvalidPassword):
+		return writePasswordForm(w, h.unlockAddress(id), "Wrong password, try again.", http.StatusUnauthorized)
+	case errors.Is(err, service.ErrTooManyAttempts):
+		setRetryAfter(w)
+		return writePasswordForm(w, h.unlockAddress(id), "Too many attempts, try again later.", http.StatusTooManyRequests)
+	case err != nil:
+		logger.FromContext(r.Context()).Error("failed to unlock url", zap.String("error", err.Error()))
+		return err
+	}
 
+	if record.Threat != "" {
+		return writeInterstitial(w, record)
+	}
+	// form is posted, so browser follows redirect with GET
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", record.URL)
+	w.WriteHeader(http.StatusSeeOther)
 	return nil
 }
 
+// protected url is locked at most for password lockout time
+func setRetryAfter(w http.ResponseWriter) {
+	lockout := time.Duration(config.ServerConfig.PasswordLockout)
+	w.Header().Set("Retry-After", strconv.Itoa(int(lockout.Seconds())))
+}
+
 // delete batch of urls
 func (h URLHandler) DeleteURLS(w http.ResponseWriter, r *http.Request) error {
 	var urls []string
 	if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
-		logger.Log.Error("failed to decode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to decode body", zap.String("error", err.Error()))
 		return err
 	}
 
@@ -155,9 +255,9 @@ func (h URLHandler) DeleteURLS(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	err = h.service.DeleteURLS(urls, userID)
+	err = h.service.DeleteURLS(r.Context(), urls, userID)
 	if err != nil {
-		logger.Log.Error("failed to delete urls", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to delete urls", zap.String("error", err.Error()))
 		return errAccessDenied
 	}
 
@@ -166,16 +266,129 @@ func (h URLHandler) DeleteURLS(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// get statistic
-func (h URLHandler) GetStats(w http.ResponseWriter, r *http.Request) error {
-	realIP := r.Header.Get("X-Real-IP")
-	ip := net.ParseIP(realIP)
+// missing url of user url request is not found error
+func userURLError(err error) error {
+	if errors.Is(err, repository.ErrURLNotFound) {
+		return fmt.Errorf("%w: %w", errUserURLNotFound, err)
+	}
+	return err
+}
+
+// write url record with full short url
+func (h URLHandler) writeURLRecord(w http.ResponseWriter, r *http.Request, id string, record models.URLRecord) error {
+	record.ShortURL = h.createResponseAddress(id)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(record); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// change destination, then title, tags and notes of user url
+func (h URLHandler) UpdateURL(w http.ResponseWriter, r *http.Request) error {
+	var req models.UpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.FromContext(r.Context()).Error("failed to decode body", zap.String("error", err.Error()))
+		return err
+	}
+
+	updateMetadata := req.Title != nil || req.Tags != nil || req.Notes != nil
+	if len(req.URL) == 0 && !updateMetadata {
+		logger.FromContext(r.Context()).Error("unsupported empty UpdateURL request")
+		return errUnsupportedBody
+	}
+
+	userID, err := h.getUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	id := chi.URLParam(r, "id")
+	var record models.URLRecord
+	if len(req.URL) != 0 {
+		record.OriginalURL, err = h.service.UpdateURL(r.Context(), id, userID, req.URL)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to update url", zap.String("id", id), zap.String("error", err.Error()))
+			return userURLError(err)
+		}
+	}
+	if updateMetadata {
+		record, err = h.service.UpdateMetadata(r.Context(), id, userID, req)
+		if err != nil {
+			logger.FromContext(r.Context()).Error("failed to update url metadata", zap.String("id", id), zap.String("error", err.Error()))
+			return userURLError(err)
+		}
+	}
+
+	return h.writeURLRecord(w, r, id, record)
+}
+
+// get revisions of user url
+func (h URLHandler) GetRevisions(w http.ResponseWriter, r *http.Request) error {
+	userID, err := h.getUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.service.GetRevisions(r.Context(), chi.URLParam(r, "id"), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to get revisions", zap.String("error", err.Error()))
+		return userURLError(err)
+	}
+	if revisions == nil {
+		revisions = []models.URLRevision{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(revisions); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// restore destination of user url before revision
+func (h URLHandler) RollbackURL(w http.ResponseWriter, r *http.Request) error {
+	var req models.RollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.FromContext(r.Context()).Error("failed to decode body", zap.String("error", err.Error()))
+		return err
+	}
+
+	userID, err := h.getUserID(r.Context())
+	if err != nil {
+		return err
+	}
+
+	id := chi.URLParam(r, "id")
+	url, err := h.service.RollbackURL(r.Context(), id, userID, req.Revision)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to rollback url", zap.String("id", id), zap.String("error", err.Error()))
+		return userURLError(err)
+	}
+
+	return h.writeURLRecord(w, r, id, models.URLRecord{OriginalURL: url})
+}
 
+// check request comes from trusted subnet
+func (h URLHandler) checkTrusted(r *http.Request) error {
+	ip := net.ParseIP(r.Header.Get("X-Real-IP"))
 	if !h.trustedSubnet.Contains(ip) {
 		return errForbidden
 	}
+	return nil
+}
+
+// get statistic
+func (h URLHandler) GetStats(w http.ResponseWriter, r *http.Request) error {
+	if err := h.checkTrusted(r); err != nil {
+		return err
+	}
 
-	resp, err := h.service.GetStats()
+	resp, err := h.service.GetStats(r.Context())
 	if err != nil {
 		return err
 	}
@@ -183,13 +396,73 @@ func (h URLHandler) GetStats(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(resp); err != nil {
-		logger.Log.Error("failed encode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
 		return err
 	}
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
 
+// parse day of query parameter, empty value is replaced with default
+func parseDay(value string, defaultDay time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultDay, nil
+	}
+	return time.Parse(models.DayLayout, value)
+}
+
+// get daily counts of created urls and redirects, from and to days are inclusive,
+// last 30 days are returned by default; csv is returned for format=csv or Accept: text/csv
+func (h URLHandler) GetTimeSeries(w http.ResponseWriter, r *http.Request) error {
+	if err := h.checkTrusted(r); err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	if bucket := query.Get("bucket"); bucket != "" && bucket != "day" {
+		return errUnsupportedBucket
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, err := parseDay(query.Get("to"), today)
+	if err != nil {
+		return err
+	}
+	from, err := parseDay(query.Get("from"), to.AddDate(0, 0, -29))
+	if err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return errInvalidRange
+	}
+
+	points, err := h.service.GetTimeSeries(r.Context(), from, to.AddDate(0, 0, 1))
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to get time series", zap.String("error", err.Error()))
+		return err
+	}
+
+	if query.Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		return writeTimeSeriesCSV(w, points)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(points); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func writeTimeSeriesCSV(w http.ResponseWriter, points []models.TimeSeriesPoint) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"day", "user_id", "domain", "created", "redirects"})
+	for _, p := range points {
+		writer.Write([]string{p.Day, p.UserID, p.Domain, strconv.Itoa(p.Created), strconv.FormatInt(p.Redirects, 10)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
 // get batch of urls
 func (h URLHandler) GetURLS(w http.ResponseWriter, r *http.Request) error {
 	userID, err := h.getUserID(r.Context())
@@ -197,41 +470,74 @@ func (h URLHandler) GetURLS(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	urls, err := h.service.GetURLS(userID)
-	for k, v := range urls {
-		urls[k].ShortURL = h.createResponseAddress(v.ShortURL)
+	query := r.URL.Query()
+	filter, err := parseURLFilter(query)
+	if err != nil {
+		return err
 	}
 
+	page, err := h.service.GetURLS(r.Context(), userID, filter)
 	if err != nil {
-		logger.Log.Error("failed to get urls by user id", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to get urls by user id", zap.String("error", err.Error()))
 		return err
 	}
 
+	urls := page.URLS
+	for k, v := range urls {
+		urls[k].ShortURL = h.createResponseAddress(v.ShortURL)
+	}
+
 	if len(urls) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return nil
 	}
 
+	if page.NextCursor != "" {
+		query.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, h.address, r.URL.Path, query.Encode()))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(urls); err != nil {
-		logger.Log.Error("failed encode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
 		return err
 	}
 
 	return nil
 }
 
+// filter of user urls from tag, search, include_deleted, order, cursor and limit query parameters
+func parseURLFilter(query url.Values) (models.URLFilter, error) {
+	filter := models.URLFilter{
+		Tag:    query.Get("tag"),
+		Search: query.Get("search"),
+		Order:  query.Get("order"),
+		Cursor: query.Get("cursor"),
+	}
+	var err error
+	if value := query.Get("include_deleted"); value != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(value); err != nil {
+			return models.URLFilter{}, err
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			return models.URLFilter{}, err
+		}
+	}
+	return filter, nil
+}
+
 // create short url with json body
 func (h URLHandler) CreateShortenWithJSONBody(w http.ResponseWriter, r *http.Request) error {
 	var req models.Request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Log.Error("failed to decode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to decode body", zap.String("error", err.Error()))
 		return err
 	}
 
 	if len(req.URL) == 0 {
-		logger.Log.Error("unsupported empty body in CreateShorten request")
+		logger.FromContext(r.Context()).Error("unsupported empty body in CreateShorten request")
 		return errUnsupportedBody
 	}
 
@@ -240,7 +546,7 @@ func (h URLHandler) CreateShortenWithJSONBody(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	id, err := h.service.CreateURL([]byte(req.URL), userID)
+	id, err := h.service.CreateURL(r.Context(), []byte(req.URL), userID, req.URLOptions)
 
 	if errors.Is(err, repository.ErrConflict) {
 		writeErr := h.writeURLBodyInJSON(w, id, http.StatusConflict)
@@ -251,24 +557,24 @@ func (h URLHandler) CreateShortenWithJSONBody(w http.ResponseWriter, r *http.Req
 	}
 
 	if err != nil {
-		logger.Log.Error("failed create url from request body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed create url from request body", zap.String("error", err.Error()))
 		return err
 	}
 
 	return h.writeURLBodyInJSON(w, id, http.StatusCreated)
 }
 
-// pind database
-func (h URLHandler) PingDB(w http.ResponseWriter, r *http.Request) {
-	err := h.service.PingDB()
+// health report of storage and database
+func (h URLHandler) Ping(w http.ResponseWriter, r *http.Request) {
+	report := h.service.Health(r.Context())
 
-	if err != nil {
-		logger.Log.Error("failed to ping db", zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != models.HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
 
 // create batch of short url
@@ -276,12 +582,12 @@ func (h URLHandler) CreateBatch(w http.ResponseWriter, r *http.Request) error {
 	var req models.BatchRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Log.Error("failed to decode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to decode body", zap.String("error", err.Error()))
 		return err
 	}
 
 	if len(req) == 0 {
-		logger.Log.Error("unsupported empty body in CreateBatch request")
+		logger.FromContext(r.Context()).Error("unsupported empty body in CreateBatch request")
 		return errUnsupportedBody
 	}
 
@@ -297,10 +603,10 @@ func (h URLHandler) CreateBatch(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	shortURLS, err := h.service.CreateURLS(originalURLS, userID)
+	shortURLS, err := h.service.CreateURLS(r.Context(), originalURLS, userID)
 
 	if err != nil {
-		logger.Log.Error("failed create urls", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed create urls", zap.String("error", err.Error()))
 		return err
 	}
 
@@ -315,7 +621,7 @@ func (h URLHandler) CreateBatch(w http.ResponseWriter, r *http.Request) error {
 	enc := json.NewEncoder(w)
 
 	if err := enc.Encode(response); err != nil {
-		logger.Log.Error("failed encode body", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed encode body", zap.String("error", err.Error()))
 		return err
 	}
 
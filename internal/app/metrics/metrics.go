@@ -0,0 +1,156 @@
+// metrics package contains prometheus metrics of http, grpc, service and storage layers
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+// Registry - registry with all service metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests by route pattern and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route pattern and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Number of grpc requests by method and code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of grpc requests by method and code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Latency of repository operations by backend.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation"})
+
+	storageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_errors_total",
+		Help:      "Number of failed repository operations by backend.",
+	}, []string{"backend", "operation"})
+
+	// PendingDeletions - number of asynchronous deletions in progress
+	PendingDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_deletions",
+		Help:      "Number of asynchronous url deletions in progress.",
+	})
+
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build version and commit of running binary, value is always 1.",
+	}, []string{"version", "commit"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		grpcRequests,
+		grpcDuration,
+		storageDuration,
+		storageErrors,
+		PendingDeletions,
+		buildInfo,
+	)
+}
+
+// handler that exposes metrics in prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// set build version and commit of running binary
+func SetBuildInfo(version, commit string) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, commit).Set(1)
+}
+
+// observe finished http request
+func ObserveHTTP(method, route, status string, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, status).Inc()
+	httpDuration.WithLabelValues(method, route, status).Observe(duration.Seconds())
+}
+
+// observe finished grpc request
+func ObserveGRPC(method, code string, duration time.Duration) {
+	grpcRequests.WithLabelValues(method, code).Inc()
+	grpcDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+// observe finished repository operation
+func ObserveStorage(backend, operation string, duration time.Duration, err error) {
+	storageDuration.WithLabelValues(backend, operation).Observe(duration.Seconds())
+	if err != nil {
+		storageErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
+
+// register connection pool stats of database, returned function unregisters them
+func RegisterDBStats(db *sql.DB, name string) func() {
+	collector := collectors.NewDBStatsCollector(db, name)
+	if err := Registry.Register(collector); err != nil {
+		return func() {}
+	}
+	return func() {
+		Registry.Unregister(collector)
+	}
+}
+
+// register hit and miss counters of redirect cache, returned function unregisters them
+func RegisterCacheStats(hits, misses func() uint64) func() {
+	cacheCollectors := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Number of redirect cache hits.",
+		}, func() float64 { return float64(hits()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Number of redirect cache misses.",
+		}, func() float64 { return float64(misses()) }),
+	}
+	unregister := func() {
+		for _, collector := range cacheCollectors {
+			Registry.Unregister(collector)
+		}
+	}
+	for _, collector := range cacheCollectors {
+		if err := Registry.Register(collector); err != nil {
+			unregister()
+			return func() {}
+		}
+	}
+	return unregister
+}
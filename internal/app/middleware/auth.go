@@ -30,13 +30,13 @@ func GetUserIDFromCookie(r *http.Request) (string, error) {
 	key := sha256.Sum256([]byte(password))
 	aesblock, err := aes.NewCipher(key[:])
 	if err != nil {
-		logger.Log.Error("failed to create new cipher", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to create new cipher", zap.String("error", err.Error()))
 		return "", err
 	}
 
 	aesgcm, err := cipher.NewGCM(aesblock)
 	if err != nil {
-		logger.Log.Error("failed to create new gcm", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to create new gcm", zap.String("error", err.Error()))
 		return "", err
 	}
 
@@ -44,13 +44,13 @@ func GetUserIDFromCookie(r *http.Request) (string, error) {
 
 	data, err := hex.DecodeString(userIDCookie.Value)
 	if err != nil {
-		logger.Log.Error("failed to decode userID cookie", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to decode userID cookie", zap.String("error", err.Error()))
 		return "", err
 	}
 
 	userID, err := aesgcm.Open(nil, nonce, data, nil)
 	if err != nil {
-		logger.Log.Error("failed to decrypt userID cookie", zap.String("error", err.Error()))
+		logger.FromContext(r.Context()).Error("failed to decrypt userID cookie", zap.String("error", err.Error()))
 		return "", err
 	}
 	return string(userID), err
@@ -89,12 +89,13 @@ func WithUserID(h http.Handler) http.Handler {
 		}
 
 		if err != nil {
-			logger.Log.Error("failed to set user id", zap.String("error", err.Error()))
+			logger.FromContext(r.Context()).Error("failed to set user id", zap.String("error", err.Error()))
 			w.WriteHeader(http.StatusInternalServerError)
 		}
 
-		logger.Log.Info("WithUserID", zap.String("userID", userID))
 		ctx := context.WithValue(r.Context(), service.UserIDKey, userID)
+		ctx = logger.WithFields(ctx, zap.String("user_id", userID))
+		logger.FromContext(ctx).Info("WithUserID")
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
 
@@ -110,6 +111,7 @@ func WithAuth(h http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), service.UserIDKey, userID)
+		ctx = logger.WithFields(ctx, zap.String("user_id", userID))
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
 
@@ -14,15 +14,23 @@ type gzipWriter struct {
 	Writer *gzip.Writer
 }
 
+// compressed content types, parameters like charset are ignored
+var compressedContentTypes = []string{"application/json", "text/html", "text/csv", "application/x-ndjson"}
+
 func (w *gzipWriter) useCompression() bool {
-	contentType := w.Header().Get("Content-Type")
-	return contentType == "application/json" || contentType == "text/html"
+	contentType, _, _ := strings.Cut(w.Header().Get("Content-Type"), ";")
+	for _, t := range compressedContentTypes {
+		if strings.TrimSpace(contentType) == t {
+			return true
+		}
+	}
+	return false
 }
 
 // write body with gzip comprassion
 func (w *gzipWriter) Write(b []byte) (int, error) {
 	if w.useCompression() {
-		w.ResponseWriter.Header().Add("Content-Encoding", "gzip")
+		w.ResponseWriter.Header().Set("Content-Encoding", "gzip")
 		return w.Writer.Write(b)
 	}
 	return w.ResponseWriter.Write(b)
@@ -31,11 +39,25 @@ func (w *gzipWriter) Write(b []byte) (int, error) {
 // write header with gzip
 func (w *gzipWriter) WriteHeader(statusCode int) {
 	if w.useCompression() {
-		w.ResponseWriter.Header().Add("Content-Encoding", "gzip")
+		w.ResponseWriter.Header().Set("Content-Encoding", "gzip")
 	}
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// send compressed data written so far, used by streamed responses
+func (w *gzipWriter) Flush() {
+	if w.useCompression() {
+		w.ResponseWriter.Header().Set("Content-Encoding", "gzip")
+		w.Writer.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// underlying writer, lets http.ResponseController reach it
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // close
 func (w *gzipWriter) Close() error {
 	if w.useCompression() {
@@ -50,17 +72,17 @@ func WithCompress(h http.Handler) http.Handler {
 		ow := w
 
 		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			logger.Log.Info("Using gzip writer for request")
+			logger.FromContext(r.Context()).Info("Using gzip writer for request")
 			gz := &gzipWriter{ResponseWriter: w, Writer: gzip.NewWriter(w)}
 			defer gz.Close()
 			ow = gz
 		}
 
 		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
-			logger.Log.Info("Using gzip reader for request")
+			logger.FromContext(r.Context()).Info("Using gzip reader for request")
 			gr, err := gzip.NewReader(r.Body)
 			if err != nil {
-				logger.Log.Error("failed to create gzip reader", zap.String("error", err.Error()))
+				logger.FromContext(r.Context()).Error("failed to create gzip reader", zap.String("error", err.Error()))
 				w.WriteHeader(http.StatusBadRequest)
 			}
 			r.Body = gr
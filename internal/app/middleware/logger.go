@@ -1,11 +1,22 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rutkin/url-shortener/internal/app/logger"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type (
@@ -33,8 +44,102 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
-// logging middleware
-func WithLogging(h http.Handler) http.Handler {
+// underlying writer, lets http.ResponseController flush streamed responses
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// access log formats
+const (
+	AccessLogJSON     = "json"
+	AccessLogCombined = "combined"
+	AccessLogNone     = "none"
+)
+
+var errUnknownAccessLogFormat = errors.New("unknown access log format")
+
+// AccessLogOptions - settings of access log
+type AccessLogOptions struct {
+	// json, combined or none
+	Format string
+	// access log file, empty means json lines go to service logger and combined lines to stdout
+	Filename string
+	// rotation settings of access log file
+	MaxSizeMB  int
+	MaxBackups int
+	// only every n-th redirect response is logged, errors are always logged
+	RedirectSampling int
+}
+
+// AccessLogger - access log middleware
+type AccessLogger struct {
+	format           string
+	redirectSampling uint64
+	redirects        atomic.Uint64
+	// json output, nil means request logger from context
+	log *zap.Logger
+	// combined output
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// create new access logger
+func NewAccessLogger(options AccessLogOptions) (*AccessLogger, error) {
+	l := &AccessLogger{format: options.Format, writer: os.Stdout}
+	if options.RedirectSampling > 1 {
+		l.redirectSampling = uint64(options.RedirectSampling)
+	}
+
+	switch options.Format {
+	case AccessLogJSON, AccessLogCombined:
+	case AccessLogNone:
+		return l, nil
+	default:
+		return nil, fmt.Errorf("%w '%s'", errUnknownAccessLogFormat, options.Format)
+	}
+
+	if len(options.Filename) > 0 {
+		file := &lumberjack.Logger{
+			Filename:   options.Filename,
+			MaxSize:    options.MaxSizeMB,
+			MaxBackups: options.MaxBackups,
+		}
+		l.writer = file
+		l.closer = file
+		if options.Format == AccessLogJSON {
+			l.log = zap.New(zapcore.NewCore(
+				zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+				zapcore.AddSync(file),
+				zap.InfoLevel,
+			))
+		}
+	}
+	return l, nil
+}
+
+// close access log file
+func (l *AccessLogger) Close() error {
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+	return nil
+}
+
+// skip redirects that are not sampled
+func (l *AccessLogger) sampled(status int) bool {
+	if l.redirectSampling == 0 || status < 300 || status >= 400 {
+		return true
+	}
+	return l.redirects.Add(1)%l.redirectSampling == 1
+}
+
+// access log middleware
+func (l *AccessLogger) Handler(h http.Handler) http.Handler {
+	if l.format == AccessLogNone {
+		return h
+	}
+
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
@@ -48,18 +153,73 @@ func WithLogging(h http.Handler) http.Handler {
 		}
 		h.ServeHTTP(&lw, r)
 
-		duration := time.Since(start)
+		status := responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if !l.sampled(status) {
+			return
+		}
+
+		if l.format == AccessLogCombined {
+			l.writeCombined(r, start, status, responseData.size)
+			return
+		}
 
-		logger.Log.Info("logger middleware",
+		log := l.log
+		if log == nil {
+			log = logger.FromContext(r.Context())
+		} else {
+			log = log.With(zap.String("request_id", GetRequestID(r.Context())), zap.String("remote_addr", r.RemoteAddr))
+		}
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		log.Info("access",
 			zap.String("uri", r.RequestURI),
 			zap.String("method", r.Method),
-			zap.Int("status", responseData.status),
-			zap.Duration("duration", duration),
+			zap.String("route", route),
+			zap.Int("status", status),
+			zap.Duration("duration", time.Since(start)),
 			zap.Int("size", responseData.size),
-		)
-		logger.Log.Info("logger middlerware headers",
-			zap.String("Accept-Encoding", r.Header.Get("Accept-Encoding")),
+			zap.String("referer", r.Referer()),
+			zap.String("user_agent", r.UserAgent()),
 		)
 	}
 	return http.HandlerFunc(logFn)
 }
+
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
+
+// write line in apache combined log format
+func (l *AccessLogger) writeCombined(r *http.Request, start time.Time, status, size int) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sizeField := "-"
+	if size > 0 {
+		sizeField = strconv.Itoa(size)
+	}
+	line := fmt.Sprintf("%s - - [%s] %q %d %s %q %q\n",
+		host,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+r.RequestURI+" "+r.Proto,
+		status,
+		sizeField,
+		orDash(r.Referer()),
+		orDash(r.UserAgent()),
+	)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := io.WriteString(l.writer, line); err != nil {
+		logger.FromContext(r.Context()).Error("failed to write access log", zap.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogger(t *testing.T) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://go.dev", http.StatusTemporaryRedirect)
+	})
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+
+	t.Run("combined_format", func(t *testing.T) {
+		l, err := NewAccessLogger(AccessLogOptions{Format: AccessLogCombined})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		l.writer = &out
+
+		req := httptest.NewRequest(http.MethodGet, "/ABC?x=1", nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("User-Agent", "test-agent")
+		l.Handler(notFound).ServeHTTP(httptest.NewRecorder(), req)
+
+		line := out.String()
+		assert.True(t, strings.HasPrefix(line, "192.0.2.1 - - ["), line)
+		assert.Contains(t, line, `] "GET /ABC?x=1 HTTP/1.1" 404 10 "-" "test-agent"`+"\n")
+	})
+
+	t.Run("redirects_sampled", func(t *testing.T) {
+		l, err := NewAccessLogger(AccessLogOptions{Format: AccessLogCombined, RedirectSampling: 3})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		l.writer = &out
+
+		for i := 0; i < 6; i++ {
+			l.Handler(redirect).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ABC", nil))
+		}
+		l.Handler(notFound).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/DEF", nil))
+
+		assert.Equal(t, 2, strings.Count(out.String(), " 307 "))
+		assert.Equal(t, 1, strings.Count(out.String(), " 404 "))
+	})
+
+	t.Run("unknown_format", func(t *testing.T) {
+		_, err := NewAccessLogger(AccessLogOptions{Format: "xml"})
+		assert.ErrorIs(t, err, errUnknownAccessLogFormat)
+	})
+}
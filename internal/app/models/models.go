@@ -1,8 +1,29 @@
 package models
 
+import "time"
+
+// settings of created short url
+type URLOptions struct {
+	// Title - owner-provided title shown on preview page
+	Title string `json:"title,omitempty"`
+	// Preview - preview page is shown instead of every redirect
+	Preview bool `json:"preview,omitempty"`
+	// RedirectType - redirect status code: 301, 302, 307 or 308, 307 is used by default
+	RedirectType int `json:"redirect_type,omitempty"`
+	// Password - password required for redirect, only its hash is stored
+	Password string `json:"password,omitempty"`
+	// MaxClicks - number of redirects allowed, 0 is unlimited
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// Tags - owner-provided labels used to filter urls
+	Tags []string `json:"tags,omitempty"`
+	// Notes - owner-provided free-form notes
+	Notes string `json:"notes,omitempty"`
+}
+
 // Request to create short url
 type Request struct {
 	URL string `json:"url"`
+	URLOptions
 }
 
 // Response with short url
@@ -30,12 +51,144 @@ type BatchResponse []BatchResponseRecord
 
 // url record
 type URLRecord struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string   `json:"short_url"`
+	OriginalURL string   `json:"original_url"`
+	Title       string   `json:"title,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Deleted     bool     `json:"deleted,omitempty"`
+}
+
+// creation order of user urls
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// filter and page of user urls
+type URLFilter struct {
+	// Tag - only urls with tag are listed, empty tag lists all urls
+	Tag string
+	// Search - case-insensitive substring of original url
+	Search string
+	// IncludeDeleted - deleted urls are listed too
+	IncludeDeleted bool
+	// Order - OrderAsc or OrderDesc creation order
+	Order string
+	// Cursor - position after last url of previous page, empty for first page
+	Cursor string
+	// Limit - max number of urls in page
+	Limit int
+}
+
+// page of user urls
+type URLPage struct {
+	URLS []URLRecord
+	// NextCursor - cursor of next page, empty for last page
+	NextCursor string
+}
+
+// request to change destination or metadata of short url, missing fields are not changed
+type UpdateRequest struct {
+	URL   string    `json:"url,omitempty"`
+	Title *string   `json:"title,omitempty"`
+	Tags  *[]string `json:"tags,omitempty"`
+	Notes *string   `json:"notes,omitempty"`
+}
+
+// change of short url destination, rollback to revision restores its old url
+type URLRevision struct {
+	Revision  int       `json:"revision"`
+	OldURL    string    `json:"old_url"`
+	NewURL    string    `json:"new_url"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// request to restore destination of short url before revision
+type RollbackRequest struct {
+	Revision int `json:"revision"`
+}
+
+// statuses of imported rows
+const (
+	ImportCreated  = "created"
+	ImportConflict = "conflict"
+	ImportSkipped  = "skipped"
+	ImportFailed   = "failed"
+)
+
+// result of imported row, rows are numbered from 1
+type ImportResult struct {
+	Row      int    `json:"row"`
+	ShortURL string `json:"short_url,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// preview of short url destination
+type URLPreview struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	Title       string    `json:"title,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Threat      string    `json:"threat,omitempty"`
+}
+
+// build information of running binary
+type BuildInfo struct {
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	Commit  string `json:"commit"`
+}
+
+// health statuses
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// health of service component
+type ComponentHealth struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// health report, service is healthy when all components are healthy
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+// DayLayout - format of days in stats
+const DayLayout = "2006-01-02"
+
+// daily number of created urls and redirects of user urls by domain
+type TimeSeriesPoint struct {
+	Day       string `json:"day"`
+	UserID    string `json:"user_id"`
+	Domain    string `json:"domain"`
+	Created   int    `json:"created"`
+	Redirects int64  `json:"redirects"`
+}
+
+// number of active urls of domain
+type DomainStat struct {
+	Domain string `json:"domain"`
+	URLS   int    `json:"urls"`
 }
 
-// statictics record
+// statictics record, urls and users count all stored urls including deleted ones
 type StatRecord struct {
-	URLS  int `json:"urls"`
-	Users int `json:"users"`
+	URLS            int          `json:"urls"`
+	Users           int          `json:"users"`
+	ActiveURLS      int          `json:"active_urls"`
+	DeletedURLS     int          `json:"deleted_urls"`
+	CreatedLastDay  int          `json:"created_last_day"`
+	CreatedLastWeek int          `json:"created_last_week"`
+	Redirects       int64        `json:"redirects"`
+	TopDomains      []DomainStat `json:"top_domains"`
 }
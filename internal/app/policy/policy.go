@@ -0,0 +1,289 @@
+// policy package decides which destination domains can be shortened and redirected to
+package policy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+// ErrDomainBlocked - destination domain is blocked or is not in allowlist
+var ErrDomainBlocked = errors.New("domain is blocked")
+
+// ErrInvalidRule - rule has unknown action or malformed pattern
+var ErrInvalidRule = errors.New("invalid domain rule")
+
+// ErrRuleNotFound - removed rule doesn't exist
+var ErrRuleNotFound = errors.New("domain rule not found")
+
+// rule actions
+const (
+	ActionBlock = "block"
+	ActionAllow = "allow"
+)
+
+// Rule - domain pattern with action, pattern is domain name or *.domain for its subdomains
+type Rule struct {
+	Action  string `json:"action"`
+	Pattern string `json:"pattern"`
+}
+
+// String - rule in file format
+func (r Rule) String() string {
+	return r.Action + " " + r.Pattern
+}
+
+// matches domain in lowercase ascii form
+func (r Rule) matches(domain string) bool {
+	if suffix, ok := strings.CutPrefix(r.Pattern, "*."); ok {
+		return strings.HasSuffix(domain, "."+suffix)
+	}
+	return domain == r.Pattern
+}
+
+// check action and convert pattern to lowercase ascii form
+func normalizeRule(rule Rule) (Rule, error) {
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	if rule.Action != ActionBlock && rule.Action != ActionAllow {
+		return Rule{}, fmt.Errorf("%w: unknown action '%s'", ErrInvalidRule, rule.Action)
+	}
+	pattern := strings.TrimSuffix(strings.TrimSpace(rule.Pattern), ".")
+	wildcard := strings.HasPrefix(pattern, "*.")
+	domain, err := idna.Lookup.ToASCII(strings.TrimPrefix(pattern, "*."))
+	if err != nil || domain == "" || strings.Contains(domain, "*") {
+		return Rule{}, fmt.Errorf("%w: malformed pattern '%s'", ErrInvalidRule, rule.Pattern)
+	}
+	if wildcard {
+		domain = "*." + domain
+	}
+	rule.Pattern = domain
+	return rule, nil
+}
+
+// ParseRules - read rules in file format: one "block|allow pattern" rule per line, # starts comment
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidRule, line)
+		}
+		rule, err := normalizeRule(Rule{Action: fields[0], Pattern: fields[1]})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// create new instance of domain policy, rules are loaded from file and reloaded when file changes;
+// empty filename keeps rules only in memory
+func NewDomainPolicy(filename string, reloadInterval time.Duration) (*DomainPolicy, error) {
+	p := &DomainPolicy{filename: filename, stop: make(chan struct{}), done: make(chan struct{})}
+	if filename == "" || reloadInterval <= 0 {
+		close(p.done)
+	}
+	if filename == "" {
+		return p, nil
+	}
+	if _, err := p.reload(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if reloadInterval > 0 {
+		go p.reloadLoop(reloadInterval)
+	}
+	return p, nil
+}
+
+// DomainPolicy - block and allow rules of destination domains, block rules win;
+// when allow rules exist only matching domains are permitted
+type DomainPolicy struct {
+	filename string
+	mu       sync.RWMutex
+	rules    []Rule
+	modTime  time.Time
+	size     int64
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// reload rules when file is changed, returns whether rules were reloaded
+func (p *DomainPolicy) reload() (bool, error) {
+	info, err := os.Stat(p.filename)
+	if err != nil {
+		return false, err
+	}
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime) && info.Size() == p.size
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	f, err := os.Open(p.filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	p.rules = rules
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	p.mu.Unlock()
+	return true, nil
+}
+
+// reload rules periodically until policy is closed, broken file keeps previous rules
+func (p *DomainPolicy) reloadLoop(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := p.reload()
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				logger.Log.Error("failed to reload domain policy", zap.String("filename", p.filename), zap.String("error", err.Error()))
+			} else if reloaded {
+				logger.Log.Info("domain policy reloaded", zap.String("filename", p.filename))
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Check - check domain is permitted
+func (p *DomainPolicy) Check(domain string) error {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	allowed, hasAllow := false, false
+	for _, rule := range p.rules {
+		switch rule.Action {
+		case ActionBlock:
+			if rule.matches(domain) {
+				return fmt.Errorf("%w: %s", ErrDomainBlocked, domain)
+			}
+		case ActionAllow:
+			hasAllow = true
+			allowed = allowed || rule.matches(domain)
+		}
+	}
+	if hasAllow && !allowed {
+		return fmt.Errorf("%w: %s is not allowed", ErrDomainBlocked, domain)
+	}
+	return nil
+}
+
+// CheckURL - check domain of url is permitted
+func (p *DomainPolicy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return p.Check(u.Hostname())
+}
+
+// Rules - copy of current rules
+func (p *DomainPolicy) Rules() []Rule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Rule{}, p.rules...)
+}
+
+// Add - add rule and save rules to file, existing rule is not duplicated
+func (p *DomainPolicy) Add(rule Rule) error {
+	rule, err := normalizeRule(rule)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, existing := range p.rules {
+		if existing == rule {
+			return nil
+		}
+	}
+	return p.save(append(append([]Rule{}, p.rules...), rule))
+}
+
+// Remove - remove rule and save rules to file
+func (p *DomainPolicy) Remove(rule Rule) error {
+	rule, err := normalizeRule(rule)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rules := make([]Rule, 0, len(p.rules))
+	for _, existing := range p.rules {
+		if existing != rule {
+			rules = append(rules, existing)
+		}
+	}
+	if len(rules) == len(p.rules) {
+		return ErrRuleNotFound
+	}
+	return p.save(rules)
+}
+
+// replace rules and file atomically, caller holds lock
+func (p *DomainPolicy) save(rules []Rule) error {
+	if p.filename != "" {
+		tmp, err := os.CreateTemp(filepath.Dir(p.filename), filepath.Base(p.filename)+".*")
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(tmp)
+		for _, rule := range rules {
+			fmt.Fprintln(w, rule)
+		}
+		err = w.Flush()
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), p.filename)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if info, err := os.Stat(p.filename); err == nil {
+			p.modTime = info.ModTime()
+			p.size = info.Size()
+		}
+	}
+	p.rules = rules
+	return nil
+}
+
+// Close - stop reloading of rules
+func (p *DomainPolicy) Close() {
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+}
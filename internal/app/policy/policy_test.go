@@ -0,0 +1,89 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# phishing hosts
+block *.Evil.com
+BLOCK пример.рф # idn
+allow go.dev
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Action: ActionBlock, Pattern: "*.evil.com"},
+		{Action: ActionBlock, Pattern: "xn--e1afmkfd.xn--p1ai"},
+		{Action: ActionAllow, Pattern: "go.dev"},
+	}, rules)
+
+	_, err = ParseRules(strings.NewReader("deny evil.com"))
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	_, err = ParseRules(strings.NewReader("block evil.*.com"))
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestCheck(t *testing.T) {
+	p, err := NewDomainPolicy("", 0)
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.NoError(t, p.Add(Rule{Action: ActionBlock, Pattern: "*.evil.com"}))
+	assert.NoError(t, p.Check("evil.com"))
+	assert.ErrorIs(t, p.Check("login.evil.com"), ErrDomainBlocked)
+	assert.ErrorIs(t, p.CheckURL("https://a.b.EVIL.com/path"), ErrDomainBlocked)
+	assert.NoError(t, p.Check("notevil.com"))
+
+	require.NoError(t, p.Add(Rule{Action: ActionAllow, Pattern: "go.dev"}))
+	require.NoError(t, p.Add(Rule{Action: ActionAllow, Pattern: "*.evil.com"}))
+	assert.NoError(t, p.Check("go.dev"))
+	assert.ErrorIs(t, p.Check("notevil.com"), ErrDomainBlocked)
+	assert.ErrorIs(t, p.Check("login.evil.com"), ErrDomainBlocked, "block rule wins")
+
+	require.NoError(t, p.Remove(Rule{Action: ActionAllow, Pattern: "go.dev"}))
+	assert.ErrorIs(t, p.Remove(Rule{Action: ActionAllow, Pattern: "go.dev"}), ErrRuleNotFound)
+	assert.Len(t, p.Rules(), 2)
+}
+
+func TestReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.txt")
+	require.NoError(t, os.WriteFile(filename, []byte("block evil.com\n"), 0666))
+
+	p, err := NewDomainPolicy(filename, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer p.Close()
+	assert.ErrorIs(t, p.Check("evil.com"), ErrDomainBlocked)
+
+	t.Run("file_change_is_reloaded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("block evil.com\nblock phishing.net\n"), 0666))
+		assert.Eventually(t, func() bool {
+			return p.Check("phishing.net") != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("broken_file_keeps_rules", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("broken\n"), 0666))
+		time.Sleep(50 * time.Millisecond)
+		assert.ErrorIs(t, p.Check("evil.com"), ErrDomainBlocked)
+	})
+
+	t.Run("changes_are_saved", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("block evil.com\n"), 0666))
+		assert.Eventually(t, func() bool {
+			return len(p.Rules()) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, p.Add(Rule{Action: ActionBlock, Pattern: "*.phishing.net"}))
+		content, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		assert.Equal(t, "block evil.com\nblock *.phishing.net\n", string(content))
+	})
+}
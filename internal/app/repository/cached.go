@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats - counters of redirect cache usage
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheEntry struct {
+	id      string
+	record  URLRecord
+	err     error
+	expires time.Time
+}
+
+// create new instance of repository with read-through cache for GetURL
+func NewCachedRepository(r Repository, size int, ttl time.Duration, negativeTTL time.Duration) *cachedRepository {
+	return &cachedRepository{
+		Repository:  r,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+type cachedRepository struct {
+	Repository
+	entries     map[string]*list.Element // [shortURL, element of order with *cacheEntry]
+	order       *list.List               // most recently used entries in front
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	mu          sync.Mutex
+	// changed by every invalidation, entry loaded before invalidation isn't stored
+	generation uint64
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+}
+
+// errors that are cached as negative entries
+func isCacheableError(err error) bool {
+	return errors.Is(err, ErrURLNotFound) || errors.Is(err, ErrURLDeleted)
+}
+
+func (r *cachedRepository) get(id string) (*cacheEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	elem, ok := r.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		r.order.Remove(elem)
+		delete(r.entries, id)
+		return nil, false
+	}
+	r.order.MoveToFront(elem)
+	return entry, true
+}
+
+func (r *cachedRepository) currentGeneration() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// store entry loaded in generation, entry is dropped when urls were invalidated during its load
+func (r *cachedRepository) put(entry *cacheEntry, generation uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if generation != r.generation {
+		return
+	}
+	if elem, ok := r.entries[entry.id]; ok {
+		elem.Value = entry
+		r.order.MoveToFront(elem)
+		return
+	}
+	r.entries[entry.id] = r.order.PushFront(entry)
+	for r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+func (r *cachedRepository) invalidate(ids ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	for _, id := range ids {
+		if elem, ok := r.entries[id]; ok {
+			r.order.Remove(elem)
+			delete(r.entries, id)
+		}
+	}
+}
+
+// store urls and drop cached entries for them
+func (r *cachedRepository) CreateURLS(ctx context.Context, urls []URLRecord) error {
+	ids := make([]string, 0, len(urls))
+	for _, url := range urls {
+		ids = append(ids, url.ID)
+	}
+	defer r.invalidate(ids...)
+	return r.Repository.CreateURLS(ctx, urls)
+}
+
+// store url and drop cached entry for it
+func (r *cachedRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
+	defer r.invalidate(urlRecord.ID)
+	return r.Repository.CreateURL(ctx, urlRecord)
+}
+
+// get url from cache or from underlying repository
+func (r *cachedRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
+	if entry, ok := r.get(id); ok {
+		r.hits.Add(1)
+		return entry.record, entry.err
+	}
+	r.misses.Add(1)
+
+	generation := r.currentGeneration()
+	record, err := r.Repository.GetURL(ctx, id)
+	if err == nil {
+		r.put(&cacheEntry{id: id, record: record, expires: time.Now().Add(r.ttl)}, generation)
+	} else if isCacheableError(err) {
+		r.put(&cacheEntry{id: id, err: err, expires: time.Now().Add(r.negativeTTL)}, generation)
+	}
+	return record, err
+}
+
+// delete urls and drop cached entries for them
+func (r *cachedRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	defer r.invalidate(urls...)
+	return r.Repository.DeleteURLS(ctx, urls, userID)
+}
+
+// delete urls of domains and drop cached entries for them
+func (r *cachedRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	deleted, err := r.Repository.DeleteURLSByDomains(ctx, domains)
+	r.invalidate(deleted...)
+	return deleted, err
+}
+
+// set threats and drop cached entries of changed urls
+func (r *cachedRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	ids := make([]string, 0, len(threats))
+	for id := range threats {
+		ids = append(ids, id)
+	}
+	defer r.invalidate(ids...)
+	return r.Repository.SetThreats(ctx, threats)
+}
+
+// use click and drop cached entry with previous clicks
+func (r *cachedRepository) ClaimClick(ctx context.Context, id string) error {
+	defer r.invalidate(id)
+	return r.Repository.ClaimClick(ctx, id)
+}
+
+// change url and invalidate cached record
+func (r *cachedRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	defer r.invalidate(id)
+	return r.Repository.UpdateURL(ctx, id, userID, url)
+}
+
+// change metadata and invalidate cached record
+func (r *cachedRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	defer r.invalidate(id)
+	return r.Repository.UpdateMetadata(ctx, id, userID, update)
+}
+
+// get cache hit and miss counters
+func (r *cachedRepository) Stats() CacheStats {
+	return CacheStats{Hits: r.hits.Load(), Misses: r.misses.Load()}
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/config"
+	"github.com/rutkin/url-shortener/internal/app/resp/resptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingRepository struct {
+	*inMemoryRepository
+	gets int
+	// called after url is read from storage
+	afterGet func()
+}
+
+func (r *countingRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
+	r.gets++
+	record, err := r.inMemoryRepository.GetURL(ctx, id)
+	if r.afterGet != nil {
+		r.afterGet()
+	}
+	return record, err
+}
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("caches_found_url", func(t *testing.T) {
+		storage := &countingRepository{inMemoryRepository: NewInMemoryRepository()}
+		r := NewCachedRepository(storage, 10, time.Minute, time.Minute)
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com", UserID: "user"}))
+
+		for i := 0; i < 3; i++ {
+			url, err := r.GetURL(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, "http://example.com", url.URL)
+		}
+
+		assert.Equal(t, 1, storage.gets)
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, r.Stats())
+	})
+
+	t.Run("caches_not_found_until_created", func(t *testing.T) {
+		storage := &countingRepository{inMemoryRepository: NewInMemoryRepository()}
+		r := NewCachedRepository(storage, 10, time.Minute, time.Minute)
+
+		_, err := r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		_, err = r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		assert.Equal(t, 1, storage.gets)
+
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com", UserID: "user"}))
+		url, err := r.GetURL(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com", url.URL)
+		assert.Equal(t, 2, storage.gets)
+	})
+
+	t.Run("expires_entries", func(t *testing.T) {
+		storage := &countingRepository{inMemoryRepository: NewInMemoryRepository()}
+		r := NewCachedRepository(storage, 10, time.Nanosecond, time.Nanosecond)
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com", UserID: "user"}))
+
+		_, err := r.GetURL(ctx, "1")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = r.GetURL(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, storage.gets)
+	})
+
+	t.Run("skips_url_loaded_before_invalidation", func(t *testing.T) {
+		storage := &countingRepository{inMemoryRepository: NewInMemoryRepository()}
+		r := NewCachedRepository(storage, 10, time.Minute, time.Minute)
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com/old", UserID: "user"}))
+
+		loaded, resume := make(chan struct{}), make(chan struct{})
+		storage.afterGet = func() {
+			close(loaded)
+			<-resume
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			url, err := r.GetURL(ctx, "1")
+			assert.NoError(t, err)
+			assert.Equal(t, "http://example.com/old", url.URL)
+		}()
+
+		<-loaded
+		require.NoError(t, r.UpdateURL(ctx, "1", "user", "http://example.com/new"))
+		close(resume)
+		<-done
+
+		storage.afterGet = nil
+		url, err := r.GetURL(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/new", url.URL)
+	})
+
+	t.Run("evicts_least_recently_used", func(t *testing.T) {
+		storage := &countingRepository{inMemoryRepository: NewInMemoryRepository()}
+		r := NewCachedRepository(storage, 2, time.Minute, time.Minute)
+		require.NoError(t, r.CreateURLS(ctx, []URLRecord{
+			{ID: "1", URL: "http://example.com/1", UserID: "user"},
+			{ID: "2", URL: "http://example.com/2", UserID: "user"},
+			{ID: "3", URL: "http://example.com/3", UserID: "user"},
+		}))
+
+		for _, id := range []string{"1", "2", "1", "3", "1", "2"} {
+			_, err := r.GetURL(ctx, id)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 4}, r.Stats())
+	})
+}
+
+func TestNewRepositorySharedBackendCache(t *testing.T) {
+	server, err := resptest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	saved := config.ServerConfig
+	defer func() { config.ServerConfig = saved }()
+	config.ServerConfig.StorageBackend = "redis"
+	config.ServerConfig.RedisAddress = server.Addr()
+	config.ServerConfig.CacheSize = 10
+	config.ServerConfig.CacheTTL = config.Duration(5 * time.Minute)
+	config.ServerConfig.CacheNegativeTTL = config.Duration(time.Second)
+
+	r, err := NewRepository(nil)
+	require.NoError(t, err)
+	defer r.Close()
+	cached, ok := r.(*cachedRepository)
+	require.True(t, ok)
+	assert.Equal(t, sharedCacheTTL, cached.ttl)
+	assert.Equal(t, time.Second, cached.negativeTTL)
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const sqliteScheme = "sqlite://"
+
+// sql dialect specific parts of database repository
+type sqlDialect struct {
+	name string
+	// db.system attribute of query spans
+	system attribute.KeyValue
+	// statement to serialize concurrent migrations inside transaction
+	lockMigrations string
+	// check error is unique constraint violation
+	isConflict func(err error) bool
+	// condition that column equals to one of values, first placeholder number is arg
+	anyOf func(column string, arg int, values []string) (string, []interface{})
+	// expression of timestamp column day in models.DayLayout format
+	day func(column string) string
+	// suffix of select that locks rows until end of transaction
+	forUpdate string
+}
+
+var postgresDialect = sqlDialect{
+	name:           "postgres",
+	system:         semconv.DBSystemPostgreSQL,
+	lockMigrations: "LOCK TABLE schema_migrations IN EXCLUSIVE MODE",
+	isConflict: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code)
+	},
+	anyOf: func(column string, arg int, values []string) (string, []interface{}) {
+		return fmt.Sprintf("%s = ANY($%d)", column, arg), []interface{}{pq.Array(values)}
+	},
+	day: func(column string) string {
+		return fmt.Sprintf("to_char(%s, 'YYYY-MM-DD')", column)
+	},
+	forUpdate: " FOR UPDATE",
+}
+
+var sqliteDialect = sqlDialect{
+	name:   "sqlite",
+	system: semconv.DBSystemSqlite,
+	isConflict: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+	},
+	anyOf: func(column string, arg int, values []string) (string, []interface{}) {
+		if len(values) == 0 {
+			return "FALSE", nil
+		}
+		placeholders := make([]string, 0, len(values))
+		args := make([]interface{}, 0, len(values))
+		for i, value := range values {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", arg+i))
+			args = append(args, value)
+		}
+		return fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")), args
+	},
+	day: func(column string) string {
+		return fmt.Sprintf("substr(%s, 1, 10)", column)
+	},
+	// write transactions are started with immediate lock of database
+	forUpdate: "",
+}
+
+func isSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, sqliteScheme)
+}
+
+func dialectForDSN(dsn string) sqlDialect {
+	if isSQLiteDSN(dsn) {
+		return sqliteDialect
+	}
+	return postgresDialect
+}
+
+// open database by dsn, sqlite://path opens sqlite database in WAL mode, other dsn are opened with postgres driver
+func OpenDB(dsn string) (*sql.DB, error) {
+	if !isSQLiteDSN(dsn) {
+		return sql.Open("pgx", dsn)
+	}
+
+	path := strings.TrimPrefix(dsn, sqliteScheme)
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return sql.Open("sqlite3", "file:"+path+separator+"_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+}
@@ -1,6 +1,8 @@
 package repository
 
+import "context"
+
 func ExampleinMemoryRepository_CreateURL() {
 	repository := NewInMemoryRepository()
-	repository.CreateURL(URLRecord{ID: "1", URL: "http://example.com", UserID: "123"})
+	repository.CreateURL(context.Background(), URLRecord{ID: "1", URL: "http://example.com", UserID: "123"})
 }
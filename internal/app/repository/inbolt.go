@@ -0,0 +1,595 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// buckets layout:
+// urls - [shortURL, boltRecord]
+// users - [userID, bucket of user short urls]
+// revisions - [shortURL, json array of revisions]
+var (
+	boltURLSBucket      = []byte("urls")
+	boltUsersBucket     = []byte("users")
+	boltRevisionsBucket = []byte("revisions")
+)
+
+type boltRecord struct {
+	URL       string    `json:"url"`
+	UserID    string    `json:"userID"`
+	Deleted   bool      `json:"deleted"`
+	CreatedAt time.Time `json:"createdAt"`
+	Redirects int64     `json:"redirects"`
+	// redirects by day
+	DailyRedirects map[string]int64 `json:"dailyRedirects,omitempty"`
+	Threat         string           `json:"threat,omitempty"`
+	Title          string           `json:"title,omitempty"`
+	Preview        bool             `json:"preview,omitempty"`
+	RedirectType   int              `json:"redirectType,omitempty"`
+	PasswordHash   string           `json:"passwordHash,omitempty"`
+	MaxClicks      int              `json:"maxClicks,omitempty"`
+	Clicks         int              `json:"clicks,omitempty"`
+	Tags           []string         `json:"tags,omitempty"`
+	Notes          string           `json:"notes,omitempty"`
+}
+
+func (b boltRecord) record(id string) URLRecord {
+	return URLRecord{ID: id, URL: b.URL, UserID: b.UserID, CreatedAt: b.CreatedAt, Threat: b.Threat, Title: b.Title, Preview: b.Preview,
+		RedirectType: b.RedirectType, PasswordHash: b.PasswordHash, MaxClicks: b.MaxClicks, Clicks: b.Clicks,
+		Tags: b.Tags, Notes: b.Notes}
+}
+
+// create new instance of repository in embedded key-value store
+func NewInBoltRepository(filename string) (*inBoltRepository, error) {
+	db, err := bbolt.Open(filename, 0666, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		logger.Log.Error("Failed to open bolt repository",
+			zap.String("filename", filename),
+			zap.String("error", err.Error()))
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{boltURLSBucket, boltUsersBucket, boltRevisionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Log.Error("Failed to create buckets", zap.String("error", err.Error()))
+		db.Close()
+		return nil, err
+	}
+
+	return &inBoltRepository{db}, nil
+}
+
+type inBoltRepository struct {
+	db *bbolt.DB
+}
+
+// bolt transactions can't be interrupted, so context is checked before start
+func (r *inBoltRepository) view(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.db.View(fn)
+}
+
+func (r *inBoltRepository) update(ctx context.Context, fn func(tx *bbolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.db.Update(fn)
+}
+
+func boltPut(tx *bbolt.Tx, urlRecord URLRecord) error {
+	urls := tx.Bucket(boltURLSBucket)
+	if urls.Get([]byte(urlRecord.ID)) != nil {
+		return ErrConflict
+	}
+
+	err := boltSet(tx, urlRecord.ID, boltRecord{URL: urlRecord.URL, UserID: urlRecord.UserID, CreatedAt: createdAt(urlRecord),
+		Title: urlRecord.Title, Preview: urlRecord.Preview, RedirectType: urlRecord.RedirectType, PasswordHash: urlRecord.PasswordHash,
+		MaxClicks: urlRecord.MaxClicks, Tags: urlRecord.Tags, Notes: urlRecord.Notes})
+	if err != nil {
+		return err
+	}
+	userURLS, err := tx.Bucket(boltUsersBucket).CreateBucketIfNotExists([]byte(urlRecord.UserID))
+	if err != nil {
+		return err
+	}
+	return userURLS.Put([]byte(urlRecord.ID), nil)
+}
+
+func boltSet(tx *bbolt.Tx, id string, record boltRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltURLSBucket).Put([]byte(id), value)
+}
+
+func boltGet(tx *bbolt.Tx, id string) (boltRecord, error) {
+	var record boltRecord
+	value := tx.Bucket(boltURLSBucket).Get([]byte(id))
+	if value == nil {
+		return record, ErrURLNotFound
+	}
+	err := json.Unmarshal(value, &record)
+	return record, err
+}
+
+// store urls in one transaction, nothing is stored on conflict
+func (r *inBoltRepository) CreateURLS(ctx context.Context, urls []URLRecord) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		for _, url := range urls {
+			if err := boltPut(tx, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create urls", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// store url
+func (r *inBoltRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		return boltPut(tx, urlRecord)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create url", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// get url
+func (r *inBoltRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
+	var record boltRecord
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		record, err = boltGet(tx, id)
+		return err
+	})
+	if err != nil {
+		return URLRecord{}, err
+	}
+	if record.Deleted {
+		return URLRecord{}, ErrURLDeleted
+	}
+	return record.record(id), nil
+}
+
+// get urls of user by user index
+func (r *inBoltRepository) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error) {
+	var urls []listedURL
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		userURLS := tx.Bucket(boltUsersBucket).Bucket([]byte(userID))
+		if userURLS == nil {
+			return nil
+		}
+		return userURLS.ForEach(func(k, _ []byte) error {
+			record, err := boltGet(tx, string(k))
+			if err != nil {
+				return err
+			}
+			listed := listedURL{record.record(string(k)), record.Deleted}
+			if matchesFilter(listed, filter) {
+				urls = append(urls, listed)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get urls", zap.String("error", err.Error()))
+		return models.URLPage{}, err
+	}
+	return paginate(urls, filter)
+}
+
+// call fn for all user urls matching filter, user index is walked by bucket cursor
+// and urls are read by chunks in separate transactions, so fn is called outside of transaction
+func (r *inBoltRepository) ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error {
+	collect := func(add func(listedURL)) error {
+		err := r.view(ctx, func(tx *bbolt.Tx) error {
+			userURLS := tx.Bucket(boltUsersBucket).Bucket([]byte(userID))
+			if userURLS == nil {
+				return nil
+			}
+			cursor := userURLS.Cursor()
+			for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+				record, err := boltGet(tx, string(k))
+				if err != nil {
+					return err
+				}
+				add(listedURL{record.record(string(k)), record.Deleted})
+			}
+			return nil
+		})
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to scan user urls", zap.String("error", err.Error()))
+		}
+		return err
+	}
+	load := func(ids []string) ([]listedURL, error) {
+		urls := make([]listedURL, 0, len(ids))
+		err := r.view(ctx, func(tx *bbolt.Tx) error {
+			for _, id := range ids {
+				record, err := boltGet(tx, id)
+				if errors.Is(err, ErrURLNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				urls = append(urls, listedURL{record.record(id), record.Deleted})
+			}
+			return nil
+		})
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to get urls", zap.String("error", err.Error()))
+		}
+		return urls, err
+	}
+	return scanUserURLS(ctx, filter, collect, load, fn)
+}
+
+// mark urls of user as deleted
+func (r *inBoltRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		userURLS := tx.Bucket(boltUsersBucket).Bucket([]byte(userID))
+		if userURLS == nil {
+			return nil
+		}
+		for _, id := range urls {
+			if userURLS.Get([]byte(id)) == nil {
+				continue
+			}
+			record, err := boltGet(tx, id)
+			if err != nil {
+				return err
+			}
+			record.Deleted = true
+			if err := boltSet(tx, id, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to delete urls", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// add redirects of stored urls in one transaction
+func (r *inBoltRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		for key, count := range redirects {
+			record, err := boltGet(tx, key.ID)
+			if errors.Is(err, ErrURLNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			record.Redirects += count
+			if record.DailyRedirects == nil {
+				record.DailyRedirects = make(map[string]int64)
+			}
+			record.DailyRedirects[key.Day] += count
+			if err := boltSet(tx, key.ID, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to add redirects", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// get stats by scan of all urls
+func (r *inBoltRepository) GetStats(ctx context.Context) (models.StatRecord, error) {
+	collector := newStatsCollector(time.Now())
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltURLSBucket).ForEach(func(_, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			collector.add(record.URL, record.UserID, record.CreatedAt, record.Deleted, record.Redirects)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed get stats", zap.String("error", err.Error()))
+		return models.StatRecord{}, err
+	}
+	return collector.result(), nil
+}
+
+// get daily counts by scan of all urls
+func (r *inBoltRepository) GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error) {
+	collector := newTimeSeriesCollector(from, to)
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltURLSBucket).ForEach(func(_, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			collector.add(record.URL, record.UserID, record.CreatedAt, record.DailyRedirects)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed get time series", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return collector.result(), nil
+}
+
+// get distinct domains of not deleted urls by scan of all urls
+func (r *inBoltRepository) GetDomains(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var domains []string
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltURLSBucket).ForEach(func(_, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			domain := domainOf(record.URL)
+			if !record.Deleted && domain != "" && !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed get domains", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return domains, nil
+}
+
+// mark urls of domains as deleted in one transaction
+func (r *inBoltRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	blocked := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		blocked[domain] = true
+	}
+	var deleted []string
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		deleted = nil
+		records := make(map[string]boltRecord)
+		err := tx.Bucket(boltURLSBucket).ForEach(func(key, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !record.Deleted && blocked[domainOf(record.URL)] {
+				records[string(key)] = record
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// bucket can't be modified during iteration
+		for id, record := range records {
+			record.Deleted = true
+			if err := boltSet(tx, id, record); err != nil {
+				return err
+			}
+			deleted = append(deleted, id)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to delete urls of domains", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// call fn for not deleted urls, records are read in one transaction and fn is called after it
+func (r *inBoltRepository) ScanURLS(ctx context.Context, fn func(URLRecord) error) error {
+	var records []URLRecord
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltURLSBucket).ForEach(func(key, value []byte) error {
+			var record boltRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if !record.Deleted {
+				records = append(records, record.record(string(key)))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to scan urls", zap.String("error", err.Error()))
+		return err
+	}
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set threats of stored urls pointing to checked destinations in one transaction
+func (r *inBoltRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		for id, threat := range threats {
+			record, err := boltGet(tx, id)
+			if errors.Is(err, ErrURLNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if record.URL != threat.URL {
+				continue
+			}
+			record.Threat = threat.Type
+			if err := boltSet(tx, id, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to set threats", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// use click of url in update transaction
+func (r *inBoltRepository) ClaimClick(ctx context.Context, id string) error {
+	return r.update(ctx, func(tx *bbolt.Tx) error {
+		record, err := boltGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if record.Deleted {
+			return ErrURLDeleted
+		}
+		if record.Clicks >= record.MaxClicks {
+			return ErrURLExhausted
+		}
+		record.Clicks++
+		return boltSet(tx, id, record)
+	})
+}
+
+func boltRevisions(tx *bbolt.Tx, id string) ([]models.URLRevision, error) {
+	var revisions []models.URLRevision
+	value := tx.Bucket(boltRevisionsBucket).Get([]byte(id))
+	if value == nil {
+		return nil, nil
+	}
+	err := json.Unmarshal(value, &revisions)
+	return revisions, err
+}
+
+// get url owned by user
+func boltGetOwned(tx *bbolt.Tx, id string, userID string) (boltRecord, error) {
+	record, err := boltGet(tx, id)
+	if err != nil {
+		return record, err
+	}
+	if record.Deleted {
+		return record, ErrURLDeleted
+	}
+	if record.UserID != userID {
+		return record, ErrNotOwner
+	}
+	return record, nil
+}
+
+// change url, long url index and revisions in one transaction
+func (r *inBoltRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		record, err := boltGetOwned(tx, id, userID)
+		if err != nil {
+			return err
+		}
+		revisions, err := boltRevisions(tx, id)
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, models.URLRevision{Revision: len(revisions) + 1, OldURL: record.URL, NewURL: url, UserID: userID,
+			CreatedAt: time.Now().UTC()})
+		value, err := json.Marshal(revisions)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltRevisionsBucket).Put([]byte(id), value); err != nil {
+			return err
+		}
+		record.URL = url
+		record.Threat = ""
+		return boltSet(tx, id, record)
+	})
+	if err != nil && !errors.Is(err, ErrURLNotFound) && !errors.Is(err, ErrURLDeleted) && !errors.Is(err, ErrNotOwner) {
+		logger.FromContext(ctx).Error("Failed to update url", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// change metadata of url in update transaction
+func (r *inBoltRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	var record boltRecord
+	err := r.update(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		record, err = boltGetOwned(tx, id, userID)
+		if err != nil {
+			return err
+		}
+		if update.Title != nil {
+			record.Title = *update.Title
+		}
+		if update.Tags != nil {
+			record.Tags = *update.Tags
+		}
+		if update.Notes != nil {
+			record.Notes = *update.Notes
+		}
+		return boltSet(tx, id, record)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrURLNotFound) && !errors.Is(err, ErrURLDeleted) && !errors.Is(err, ErrNotOwner) {
+			logger.FromContext(ctx).Error("Failed to update metadata", zap.String("error", err.Error()))
+		}
+		return URLRecord{}, err
+	}
+	return record.record(id), nil
+}
+
+// get revisions of url
+func (r *inBoltRepository) GetRevisions(ctx context.Context, id string) ([]models.URLRevision, error) {
+	var revisions []models.URLRevision
+	err := r.view(ctx, func(tx *bbolt.Tx) error {
+		var err error
+		revisions, err = boltRevisions(tx, id)
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get revisions", zap.String("error", err.Error()))
+	}
+	return revisions, err
+}
+
+// check bolt file is open
+func (r *inBoltRepository) Ping(ctx context.Context) error {
+	return r.view(ctx, func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// close db
+func (r *inBoltRepository) Close() error {
+	return r.db.Close()
+}
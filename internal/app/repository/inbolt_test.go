@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInBoltRepository(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "shortener.bolt")
+	r, err := NewInBoltRepository(filename)
+	require.NoError(t, err)
+
+	require.NoError(t, r.CreateURLS(ctx, []URLRecord{
+		{ID: "1", URL: "http://example.com/1", UserID: "user1"},
+		{ID: "2", URL: "http://example.com/2", UserID: "user1"},
+	}))
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "3", URL: "http://example.com/3", UserID: "user2", Title: "Example", Preview: true, PasswordHash: "hash"}))
+
+	t.Run("conflict_rolls_back_batch", func(t *testing.T) {
+		err := r.CreateURLS(ctx, []URLRecord{
+			{ID: "4", URL: "http://example.com/4", UserID: "user2"},
+			{ID: "1", URL: "http://example.com/5", UserID: "user2"},
+		})
+		assert.ErrorIs(t, err, ErrConflict)
+		_, err = r.GetURL(ctx, "4")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+	})
+
+	t.Run("delete_only_own_urls", func(t *testing.T) {
+		require.NoError(t, r.DeleteURLS(ctx, []string{"1", "3"}, "user1"))
+
+		_, err := r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+		url, err := r.GetURL(ctx, "3")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/3", url.URL)
+		assert.Equal(t, "Example", url.Title)
+		assert.True(t, url.Preview)
+		assert.Equal(t, "hash", url.PasswordHash)
+	})
+
+	t.Run("persists_after_reopen", func(t *testing.T) {
+		require.NoError(t, r.Close())
+		r, err = NewInBoltRepository(filename)
+		require.NoError(t, err)
+
+		page, err := r.GetURLS(ctx, "user1", models.URLFilter{Order: models.OrderAsc, IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.Equal(t, []models.URLRecord{
+			{ShortURL: "1", OriginalURL: "http://example.com/1", Deleted: true},
+			{ShortURL: "2", OriginalURL: "http://example.com/2"},
+		}, page.URLS)
+
+		stats, err := r.GetStats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, stats.URLS)
+		assert.Equal(t, 2, stats.Users)
+		assert.Equal(t, 2, stats.ActiveURLS)
+		assert.Equal(t, 1, stats.DeletedURLS)
+		assert.Equal(t, 3, stats.CreatedLastDay)
+		assert.Equal(t, []models.DomainStat{{Domain: "example.com", URLS: 2}}, stats.TopDomains)
+	})
+
+	t.Run("same_long_url", func(t *testing.T) {
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "same1", URL: "http://example.com/same", UserID: "user7"}))
+		require.NoError(t, r.CreateURLS(ctx, []URLRecord{{ID: "same2", URL: "http://example.com/same", UserID: "user7"}}))
+		for _, id := range []string{"same1", "same2"} {
+			url, err := r.GetURL(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, "http://example.com/same", url.URL)
+		}
+	})
+
+	t.Run("claim_click", func(t *testing.T) {
+		testClaimClick(t, r)
+	})
+
+	t.Run("update_url", func(t *testing.T) {
+		testUpdateURL(t, r)
+	})
+
+	t.Run("update_metadata", func(t *testing.T) {
+		testUpdateMetadata(t, r)
+	})
+
+	t.Run("get_urls_page", func(t *testing.T) {
+		testGetURLSPage(t, r)
+	})
+
+	t.Run("scan_user_urls", func(t *testing.T) {
+		testScanUserURLS(t, r)
+	})
+
+	t.Run("create_urls_conflict", func(t *testing.T) {
+		testCreateURLSConflict(t, r)
+	})
+
+	require.NoError(t, r.Close())
+}
@@ -1,60 +1,76 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/jackc/pgerrcode"
-	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/lib/pq"
 	"github.com/rutkin/url-shortener/internal/app/logger"
 	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-// create new instance of database repository
-func NewInDatabaseRepository(db *sql.DB) (*inDatabaseRepository, error) {
-	tx, err := db.Begin()
-	if err != nil {
-		logger.Log.Error("Failed to create transaction", zap.String("error", err.Error()))
-		return nil, err
-	}
-
-	defer tx.Rollback()
-
-	_, err = tx.Exec("CREATE TABLE IF NOT EXISTS shortener (shortURL VARCHAR (50) UNIQUE NOT NULL, LongURL VARCHAR (1000) NOT NULL, userID VARCHAR (50) NOT NULL, deleted BOOLEAN NOT NULL)")
-	if err != nil {
-		logger.Log.Error("Failed to create table", zap.String("error", err.Error()))
-		return nil, err
-	}
-
-	tx.Exec(`CREATE INDEX IF NOT EXISTS long_url_idx ON shortener (LongURL)`)
-	err = tx.Commit()
+// create new instance of database repository, schema is migrated to the latest version
+func NewInDatabaseRepository(db *sql.DB, dialect sqlDialect) (*inDatabaseRepository, error) {
+	err := migrate(context.Background(), db, dialect)
 	if err != nil {
 		logger.Log.Error("Failed to prepare db", zap.String("error", err.Error()))
-		tx.Rollback()
 		return nil, err
 	}
-	return &inDatabaseRepository{db}, nil
+	return &inDatabaseRepository{db, dialect}, nil
 }
 
 type inDatabaseRepository struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// start client span of database operation
+func (r *inDatabaseRepository) startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "inDatabaseRepository."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(r.dialect.system, semconv.DBQueryText(query)))
+}
+
+// end span of database operation, not found and conflict results are not failures
+func endSpan(span trace.Span, err error) {
+	if isCacheableError(err) || errors.Is(err, ErrConflict) || errors.Is(err, ErrURLExhausted) || errors.Is(err, ErrNotOwner) {
+		err = nil
+	}
+	tracing.End(span, err)
 }
 
 // store urls in db
-func (r *inDatabaseRepository) CreateURLS(urls []URLRecord) error {
-	tx, err := r.db.Begin()
+func (r *inDatabaseRepository) CreateURLS(ctx context.Context, urls []URLRecord) (err error) {
+	query := `
+		INSERT INTO shortener (shortURL, LongURL, userID, deleted, created_at, domain, title, preview, redirect_type, password_hash, max_clicks, tags, notes)
+		Values ($1, $2, $3, FALSE, $4, $5, $6, $7, $8, $9, $10, $11, $12);`
+	ctx, span := r.startSpan(ctx, "CreateURLS", query)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Log.Error("Failed to create transaction", zap.String("error", err.Error()))
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
 		return err
 	}
 
 	for _, url := range urls {
-		_, err = tx.Exec("INSERT INTO shortener (shortURL, LongURL, userID, deleted) Values ($1, $2, $3, FALSE);", url.ID, url.URL, url.UserID)
+		_, err = tx.ExecContext(ctx, query, url.ID, url.URL, url.UserID, createdAt(url), domainOf(url.URL), url.Title, url.Preview, url.RedirectType, url.PasswordHash, url.MaxClicks,
+			strings.Join(url.Tags, ","), url.Notes)
 		if err != nil {
-			logger.Log.Error("Failed to create url", zap.String("error", err.Error()))
+			logger.FromContext(ctx).Error("Failed to create url", zap.String("error", err.Error()))
 			tx.Rollback()
+			if r.dialect.isConflict(err) {
+				err = ErrConflict
+			}
 			return err
 		}
 	}
@@ -62,13 +78,20 @@ func (r *inDatabaseRepository) CreateURLS(urls []URLRecord) error {
 }
 
 // store url in db
-func (r *inDatabaseRepository) CreateURL(urlRecord URLRecord) error {
-	_, err := r.db.Exec("INSERT INTO shortener (shortURL, LongURL, userID, deleted) Values ($1, $2, $3, FALSE)", urlRecord.ID, urlRecord.URL, urlRecord.UserID)
+func (r *inDatabaseRepository) CreateURL(ctx context.Context, urlRecord URLRecord) (err error) {
+	query := `
+		INSERT INTO shortener (shortURL, LongURL, userID, deleted, created_at, domain, title, preview, redirect_type, password_hash, max_clicks, tags, notes)
+		Values ($1, $2, $3, FALSE, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	ctx, span := r.startSpan(ctx, "CreateURL", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, query, urlRecord.ID, urlRecord.URL, urlRecord.UserID, createdAt(urlRecord), domainOf(urlRecord.URL),
+		urlRecord.Title, urlRecord.Preview, urlRecord.RedirectType, urlRecord.PasswordHash, urlRecord.MaxClicks,
+		strings.Join(urlRecord.Tags, ","), urlRecord.Notes)
 
 	if err != nil {
-		logger.Log.Error("Failed to insert in table", zap.String("error", err.Error()))
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+		logger.FromContext(ctx).Error("Failed to insert in table", zap.String("error", err.Error()))
+		if r.dialect.isConflict(err) {
 			err = ErrConflict
 		}
 		return err
@@ -78,80 +101,569 @@ func (r *inDatabaseRepository) CreateURL(urlRecord URLRecord) error {
 }
 
 // get url from db
-func (r *inDatabaseRepository) GetURL(id string) (string, error) {
-	row := r.db.QueryRow("SELECT LongURL, deleted FROM shortener WHERE shortURL=$1;", id)
-	var longURL string
+func (r *inDatabaseRepository) GetURL(ctx context.Context, id string) (_ URLRecord, err error) {
+	query := "SELECT " + urlRecordColumns + ", deleted FROM shortener WHERE shortURL=$1;"
+	ctx, span := r.startSpan(ctx, "GetURL", query)
+	defer func() { endSpan(span, err) }()
+
+	row := r.db.QueryRowContext(ctx, query, id)
 	var deleted bool
-	err := row.Scan(&longURL, &deleted)
+	record, err := scanURLRecord(row, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLRecord{}, ErrURLNotFound
+	}
 	if err != nil {
-		logger.Log.Error("Failed to select", zap.String("error", err.Error()))
-		return "", err
+		logger.FromContext(ctx).Error("Failed to select", zap.String("error", err.Error()))
+		return URLRecord{}, err
 	}
 	if deleted {
-		return "", ErrURLDeleted
+		return URLRecord{}, ErrURLDeleted
+	}
+	return record, nil
+}
+
+// columns of url record in order of scanURLRecord
+const urlRecordColumns = "shortURL, LongURL, userID, created_at, threat, title, preview, redirect_type, password_hash, max_clicks, clicks, tags, notes"
+
+// scan url record columns followed by extra columns
+func scanURLRecord(row interface{ Scan(...interface{}) error }, extra ...interface{}) (URLRecord, error) {
+	var record URLRecord
+	var created sql.NullTime
+	var tags string
+	err := row.Scan(append([]interface{}{&record.ID, &record.URL, &record.UserID, &created, &record.Threat, &record.Title, &record.Preview, &record.RedirectType, &record.PasswordHash,
+		&record.MaxClicks, &record.Clicks, &tags, &record.Notes}, extra...)...)
+	record.CreatedAt = created.Time
+	record.Tags = splitTags(tags)
+	return record, err
+}
+
+// query of user urls page, filter, order and keyset pagination are done by db
+func urlsPageQuery(userID string, filter models.URLFilter) (string, []interface{}, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	query := "SELECT " + urlRecordColumns + ", deleted FROM shortener WHERE userID = " + arg(userID)
+	if !filter.IncludeDeleted {
+		query += " AND NOT deleted"
+	}
+	if filter.Tag != "" {
+		// tags are stored comma separated, so every tag is surrounded by commas
+		query += ` AND ',' || tags || ',' LIKE ` + arg("%,"+escapeLike(filter.Tag)+",%") + ` ESCAPE '\'`
+	}
+	if filter.Search != "" {
+		query += ` AND LOWER(LongURL) LIKE ` + arg("%"+escapeLike(strings.ToLower(filter.Search))+"%") + ` ESCAPE '\'`
+	}
+
+	comparison, direction := "<", "DESC"
+	if filter.Order == models.OrderAsc {
+		comparison, direction = ">", "ASC"
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		query += fmt.Sprintf(" AND (created_at %s %s OR (created_at = %s AND shortURL %s %s))",
+			comparison, arg(cursor.CreatedAt), arg(cursor.CreatedAt), comparison, arg(cursor.ID))
 	}
-	return longURL, nil
+	query += fmt.Sprintf(" ORDER BY created_at %s, shortURL %s", direction, direction)
+	if filter.Limit > 0 {
+		// one more url is selected to know whether next page exists
+		query += " LIMIT " + arg(filter.Limit+1)
+	}
+	return query + ";", args, nil
 }
 
-// get urls from db
-func (r *inDatabaseRepository) GetURLS(userID string) ([]models.URLRecord, error) {
-	rows, err := r.db.Query("SELECT shortURL, LongURL FROM shortener WHERE userID=$1;", userID)
+// get page of urls from db
+func (r *inDatabaseRepository) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (_ models.URLPage, err error) {
+	query, args, err := urlsPageQuery(userID, filter)
 	if err != nil {
-		logger.Log.Error("Failed to get urls from db", zap.String("error", err.Error()))
-		return nil, err
+		return models.URLPage{}, err
 	}
-	defer rows.Close()
+	ctx, span := r.startSpan(ctx, "GetURLS", query)
+	defer func() { endSpan(span, err) }()
 
-	var result []models.URLRecord
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get urls from db", zap.String("error", err.Error()))
+		return models.URLPage{}, err
+	}
+	defer rows.Close()
 
+	var page models.URLPage
+	var last URLRecord
 	for rows.Next() {
-		err := rows.Err()
+		var deleted bool
+		record, err := scanURLRecord(rows, &deleted)
 		if err != nil {
-			logger.Log.Error("Failed to iterate db", zap.String("error", err.Error()))
-			return nil, err
+			logger.FromContext(ctx).Error("Failed to scan get urls result", zap.String("error", err.Error()))
+			return models.URLPage{}, err
 		}
-		var urlRecord models.URLRecord
-		if err := rows.Scan(&urlRecord.ShortURL, &urlRecord.OriginalURL); err != nil {
-			logger.Log.Error("Failed to scan get urls result", zap.String("error", err.Error()))
-			return nil, err
+		if filter.Limit > 0 && len(page.URLS) == filter.Limit {
+			page.NextCursor = encodeCursor(last)
+			break
 		}
-		result = append(result, urlRecord)
+		page.URLS = append(page.URLS, userURLRecord(listedURL{record, deleted}))
+		last = record
+	}
+	if err := rows.Err(); err != nil {
+		logger.FromContext(ctx).Error("Failed to iterate db", zap.String("error", err.Error()))
+		return models.URLPage{}, err
 	}
 
-	return result, nil
+	return page, nil
+}
+
+// call fn for user urls streamed from db
+func (r *inDatabaseRepository) ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) (err error) {
+	filter.Cursor, filter.Limit = "", 0
+	query, args, err := urlsPageQuery(userID, filter)
+	if err != nil {
+		return err
+	}
+	ctx, span := r.startSpan(ctx, "ScanUserURLS", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to scan user urls", zap.String("error", err.Error()))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deleted bool
+		record, err := scanURLRecord(rows, &deleted)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to scan user url", zap.String("error", err.Error()))
+			return err
+		}
+		if err := fn(userURLRecord(listedURL{record, deleted})); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 // delete urls from db
-func (r *inDatabaseRepository) DeleteURLS(urls []string, userID string) error {
+func (r *inDatabaseRepository) DeleteURLS(ctx context.Context, urls []string, userID string) (err error) {
+	condition, args := r.dialect.anyOf("shortURL", 2, urls)
 	query := `
 		UPDATE shortener SET deleted = TRUE
-		WHERE userID=$1 AND shortURL = ANY($2);`
-	_, err := r.db.Exec(query, userID, pq.Array(&urls))
+		WHERE userID=$1 AND ` + condition + ";"
+	ctx, span := r.startSpan(ctx, "DeleteURLS", query)
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, query, append([]interface{}{userID}, args...)...)
 	if err != nil {
-		logger.Log.Error("Failed to delete urls from db", zap.String("error", err.Error()))
+		logger.FromContext(ctx).Error("Failed to delete urls from db", zap.String("error", err.Error()))
 		return err
 	}
 	return nil
 }
 
 // get stats
-func (r *inDatabaseRepository) GetStats() (models.StatRecord, error) {
-	row := r.db.QueryRow("SELECT COUNT(shortURL) shortener;")
-	var urlCount int
-	err := row.Scan(&urlCount)
+func (r *inDatabaseRepository) GetStats(ctx context.Context) (_ models.StatRecord, err error) {
+	query := `
+		SELECT COUNT(*), COUNT(DISTINCT userID),
+			COALESCE(SUM(CASE WHEN deleted THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created_at >= $1 THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN created_at >= $2 THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(redirects), 0)
+		FROM shortener;`
+	ctx, span := r.startSpan(ctx, "GetStats", query)
+	defer func() { endSpan(span, err) }()
+
+	now := time.Now().UTC()
+	var stats models.StatRecord
+	err = r.db.QueryRowContext(ctx, query, now.Add(-24*time.Hour), now.Add(-7*24*time.Hour)).Scan(
+		&stats.URLS, &stats.Users, &stats.DeletedURLS, &stats.CreatedLastDay, &stats.CreatedLastWeek, &stats.Redirects)
 	if err != nil {
-		logger.Log.Error("Failed get url count", zap.String("error", err.Error()))
+		logger.FromContext(ctx).Error("Failed get url stats", zap.String("error", err.Error()))
 		return models.StatRecord{}, err
 	}
-	row = r.db.QueryRow("SELECT COUNT(userID) shortener;")
-	var userCount int
-	err = row.Scan(&userCount)
+	stats.ActiveURLS = stats.URLS - stats.DeletedURLS
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT domain, COUNT(*) FROM shortener
+		WHERE NOT deleted AND domain <> ''
+		GROUP BY domain ORDER BY COUNT(*) DESC, domain LIMIT $1;`, topDomainsLimit)
 	if err != nil {
-		logger.Log.Error("Failed get user count", zap.String("error", err.Error()))
+		logger.FromContext(ctx).Error("Failed get top domains", zap.String("error", err.Error()))
+		return models.StatRecord{}, err
+	}
+	defer rows.Close()
+
+	stats.TopDomains = []models.DomainStat{}
+	for rows.Next() {
+		var domain models.DomainStat
+		if err := rows.Scan(&domain.Domain, &domain.URLS); err != nil {
+			logger.FromContext(ctx).Error("Failed to scan top domains", zap.String("error", err.Error()))
+			return models.StatRecord{}, err
+		}
+		stats.TopDomains = append(stats.TopDomains, domain)
+	}
+	if err := rows.Err(); err != nil {
+		logger.FromContext(ctx).Error("Failed to iterate top domains", zap.String("error", err.Error()))
 		return models.StatRecord{}, err
 	}
 
-	return models.StatRecord{URLS: urlCount, Users: userCount}, nil
+	return stats, nil
+}
+
+// add redirects in one transaction, rows are updated in id order to avoid deadlocks
+func (r *inDatabaseRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) (err error) {
+	query := "UPDATE shortener SET redirects = redirects + $1 WHERE shortURL = $2;"
+	dailyQuery := `
+		INSERT INTO redirects_daily (shortURL, day, count) VALUES ($1, $2, $3)
+		ON CONFLICT (shortURL, day) DO UPDATE SET count = redirects_daily.count + excluded.count;`
+	ctx, span := r.startSpan(ctx, "AddRedirects", query)
+	defer func() { endSpan(span, err) }()
+
+	keys := make([]RedirectKey, 0, len(redirects))
+	for key := range redirects {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Day < keys[j].Day
+	})
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
+		return err
+	}
+	for _, key := range keys {
+		_, err = tx.ExecContext(ctx, query, redirects[key], key.ID)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, dailyQuery, key.ID, key.Day, redirects[key])
+		}
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to add redirects", zap.String("error", err.Error()))
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// get daily counts, created urls and redirects are grouped by user and domain of url
+func (r *inDatabaseRepository) GetTimeSeries(ctx context.Context, from, to time.Time) (_ []models.TimeSeriesPoint, err error) {
+	createdQuery := `
+		SELECT ` + r.dialect.day("created_at") + `, userID, domain, COUNT(*) FROM shortener
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY 1, userID, domain;`
+	redirectsQuery := `
+		SELECT r.day, s.userID, s.domain, SUM(r.count) FROM redirects_daily r
+		JOIN shortener s ON s.shortURL = r.shortURL
+		WHERE r.day >= $1 AND r.day < $2
+		GROUP BY r.day, s.userID, s.domain;`
+	ctx, span := r.startSpan(ctx, "GetTimeSeries", createdQuery)
+	defer func() { endSpan(span, err) }()
+
+	collector := newTimeSeriesCollector(from, to)
+	err = r.scanTimeSeries(ctx, createdQuery, func(day, userID, domain string, count int64) {
+		collector.addCreated(day, userID, domain, int(count))
+	}, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	err = r.scanTimeSeries(ctx, redirectsQuery, collector.addRedirects, collector.from, collector.to)
+	if err != nil {
+		return nil, err
+	}
+	return collector.result(), nil
+}
+
+// scan rows of day, user, domain and count
+func (r *inDatabaseRepository) scanTimeSeries(ctx context.Context, query string, add func(day, userID, domain string, count int64), args ...interface{}) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get time series", zap.String("error", err.Error()))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day, userID, domain string
+		var count int64
+		if err := rows.Scan(&day, &userID, &domain, &count); err != nil {
+			logger.FromContext(ctx).Error("Failed to scan time series", zap.String("error", err.Error()))
+			return err
+		}
+		add(day, userID, domain, count)
+	}
+	if err := rows.Err(); err != nil {
+		logger.FromContext(ctx).Error("Failed to iterate time series", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// get distinct domains of not deleted urls
+func (r *inDatabaseRepository) GetDomains(ctx context.Context) (_ []string, err error) {
+	query := "SELECT DISTINCT domain FROM shortener WHERE NOT deleted AND domain <> '';"
+	ctx, span := r.startSpan(ctx, "GetDomains", query)
+	defer func() { endSpan(span, err) }()
+
+	return r.queryStrings(ctx, query)
+}
+
+// delete urls of domains
+func (r *inDatabaseRepository) DeleteURLSByDomains(ctx context.Context, domains []string) (_ []string, err error) {
+	condition, args := r.dialect.anyOf("domain", 1, domains)
+	query := "UPDATE shortener SET deleted = TRUE WHERE NOT deleted AND " + condition + " RETURNING shortURL;"
+	ctx, span := r.startSpan(ctx, "DeleteURLSByDomains", query)
+	defer func() { endSpan(span, err) }()
+
+	return r.queryStrings(ctx, query, args...)
+}
+
+// query rows of one string column
+func (r *inDatabaseRepository) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to query db", zap.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			logger.FromContext(ctx).Error("Failed to scan query result", zap.String("error", err.Error()))
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	if err := rows.Err(); err != nil {
+		logger.FromContext(ctx).Error("Failed to iterate db", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return result, nil
+}
+
+// call fn for not deleted urls ordered by id
+func (r *inDatabaseRepository) ScanURLS(ctx context.Context, fn func(URLRecord) error) (err error) {
+	query := "SELECT " + urlRecordColumns + " FROM shortener WHERE NOT deleted ORDER BY shortURL;"
+	ctx, span := r.startSpan(ctx, "ScanURLS", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to scan urls", zap.String("error", err.Error()))
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanURLRecord(rows)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to scan url", zap.String("error", err.Error()))
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// set threats in one transaction, rows are updated in id order to avoid deadlocks;
+// row is updated only while it points to checked url
+func (r *inDatabaseRepository) SetThreats(ctx context.Context, threats map[string]Threat) (err error) {
+	query := "UPDATE shortener SET threat = $1 WHERE shortURL = $2 AND LongURL = $3;"
+	ctx, span := r.startSpan(ctx, "SetThreats", query)
+	defer func() { endSpan(span, err) }()
+
+	ids := make([]string, 0, len(threats))
+	for id := range threats {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
+		return err
+	}
+	for _, id := range ids {
+		_, err = tx.ExecContext(ctx, query, threats[id].Type, id, threats[id].URL)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to set threat", zap.String("error", err.Error()))
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// use click in one statement, row is updated only while clicks are left
+func (r *inDatabaseRepository) ClaimClick(ctx context.Context, id string) (err error) {
+	query := `
+		UPDATE shortener SET clicks = clicks + 1
+		WHERE shortURL = $1 AND NOT deleted AND clicks < max_clicks
+		RETURNING clicks;`
+	ctx, span := r.startSpan(ctx, "ClaimClick", query)
+	defer func() { endSpan(span, err) }()
+
+	var clicks int
+	err = r.db.QueryRowContext(ctx, query, id).Scan(&clicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrURLExhausted
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to claim click", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// change url in transaction, url row is locked until revision is recorded
+func (r *inDatabaseRepository) UpdateURL(ctx context.Context, id string, userID string, url string) (err error) {
+	query := "UPDATE shortener SET LongURL = $1, domain = $2, threat = '' WHERE shortURL = $3;"
+	ctx, span := r.startSpan(ctx, "UpdateURL", query)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
+		return err
+	}
+	defer tx.Rollback()
+
+	oldURL, err := r.selectOwnedURL(ctx, tx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	var revision int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(revision), 0) FROM url_revisions WHERE shortURL = $1;", id).Scan(&revision)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO url_revisions (shortURL, revision, old_url, new_url, userID, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6);`, id, revision+1, oldURL, url, userID, time.Now().UTC())
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, query, url, domainOf(url), id)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to update url", zap.String("error", err.Error()))
+		return err
+	}
+	return tx.Commit()
+}
+
+// select url owned by user, row is locked until end of transaction
+func (r *inDatabaseRepository) selectOwnedURL(ctx context.Context, tx *sql.Tx, id string, userID string) (string, error) {
+	var url, owner string
+	var deleted bool
+	err := tx.QueryRowContext(ctx, "SELECT LongURL, userID, deleted FROM shortener WHERE shortURL = $1"+r.dialect.forUpdate+";", id).
+		Scan(&url, &owner, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrURLNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to select url", zap.String("error", err.Error()))
+		return "", err
+	}
+	if deleted {
+		return "", ErrURLDeleted
+	}
+	if owner != userID {
+		return "", ErrNotOwner
+	}
+	return url, nil
+}
+
+// change given metadata columns in transaction with ownership check, row is locked until changed url is selected
+func (r *inDatabaseRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (_ URLRecord, err error) {
+	var columns []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		columns = append(columns, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if update.Title != nil {
+		set("title", *update.Title)
+	}
+	if update.Tags != nil {
+		set("tags", strings.Join(*update.Tags, ","))
+	}
+	if update.Notes != nil {
+		set("notes", *update.Notes)
+	}
+	query := fmt.Sprintf("UPDATE shortener SET %s WHERE shortURL = $%d;", strings.Join(columns, ", "), len(args)+1)
+	ctx, span := r.startSpan(ctx, "UpdateMetadata", query)
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
+		return URLRecord{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.selectOwnedURL(ctx, tx, id, userID); err != nil {
+		return URLRecord{}, err
+	}
+	if len(columns) > 0 {
+		if _, err = tx.ExecContext(ctx, query, append(args, id)...); err != nil {
+			logger.FromContext(ctx).Error("Failed to update metadata", zap.String("error", err.Error()))
+			return URLRecord{}, err
+		}
+	}
+	record, err := scanURLRecord(tx.QueryRowContext(ctx, "SELECT "+urlRecordColumns+" FROM shortener WHERE shortURL = $1;", id))
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to select url", zap.String("error", err.Error()))
+		return URLRecord{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return URLRecord{}, err
+	}
+	return record, nil
+}
+
+// get revisions of url
+func (r *inDatabaseRepository) GetRevisions(ctx context.Context, id string) (_ []models.URLRevision, err error) {
+	query := "SELECT revision, old_url, new_url, userID, changed_at FROM url_revisions WHERE shortURL = $1 ORDER BY revision;"
+	ctx, span := r.startSpan(ctx, "GetRevisions", query)
+	defer func() { endSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, query, id)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get revisions", zap.String("error", err.Error()))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.URLRevision
+	for rows.Next() {
+		var revision models.URLRevision
+		err := rows.Scan(&revision.Revision, &revision.OldURL, &revision.NewURL, &revision.UserID, &revision.CreatedAt)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to scan revision", zap.String("error", err.Error()))
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		logger.FromContext(ctx).Error("Failed to iterate revisions", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// check db connection
+func (r *inDatabaseRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
 }
 
 // close db
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteRepository(t *testing.T) *inDatabaseRepository {
+	dsn := sqliteScheme + filepath.Join(t.TempDir(), "shortener.db")
+	db, err := OpenDB(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	r, err := NewInDatabaseRepository(db, dialectForDSN(dsn))
+	require.NoError(t, err)
+	return r
+}
+
+func TestInDatabaseRepositorySQLite(t *testing.T) {
+	ctx := context.Background()
+	r := newSQLiteRepository(t)
+	now := time.Now().UTC()
+	today := now.Format(models.DayLayout)
+
+	require.NoError(t, r.CreateURLS(ctx, []URLRecord{
+		{ID: "1", URL: "http://example.com/1", UserID: "user1"},
+		{ID: "2", URL: "http://example.com/2", UserID: "user1"},
+	}))
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "3", URL: "http://example.com/3", UserID: "user2", Title: "Example", Preview: true, RedirectType: 308, PasswordHash: "hash"}))
+
+	t.Run("migrations_are_idempotent", func(t *testing.T) {
+		require.NoError(t, migrate(ctx, r.db, r.dialect))
+		var version int
+		require.NoError(t, r.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version))
+		assert.Equal(t, len(migrations), version)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		err := r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com/1", UserID: "user2"})
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("delete_only_own_urls", func(t *testing.T) {
+		require.NoError(t, r.DeleteURLS(ctx, []string{"1", "3"}, "user1"))
+
+		_, err := r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+		url, err := r.GetURL(ctx, "3")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/3", url.URL)
+		assert.Equal(t, "Example", url.Title)
+		assert.True(t, url.Preview)
+		assert.Equal(t, 308, url.RedirectType)
+		assert.Equal(t, "hash", url.PasswordHash)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "4", URL: "https://Go.dev/doc", UserID: "user2", CreatedAt: now.Add(-72 * time.Hour)}))
+		require.NoError(t, r.AddRedirects(ctx, map[RedirectKey]int64{{"2", today}: 2, {"4", today}: 5}))
+
+		stats, err := r.GetStats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, models.StatRecord{
+			URLS:            4,
+			Users:           2,
+			ActiveURLS:      3,
+			DeletedURLS:     1,
+			CreatedLastDay:  3,
+			CreatedLastWeek: 4,
+			Redirects:       7,
+			TopDomains: []models.DomainStat{
+				{Domain: "example.com", URLS: 2},
+				{Domain: "go.dev", URLS: 1},
+			},
+		}, stats)
+	})
+
+	t.Run("time_series", func(t *testing.T) {
+		from := now.Truncate(24 * time.Hour).Add(-3 * 24 * time.Hour)
+		points, err := r.GetTimeSeries(ctx, from, from.Add(4*24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []models.TimeSeriesPoint{
+			{Day: now.Add(-72 * time.Hour).Format(models.DayLayout), UserID: "user2", Domain: "go.dev", Created: 1},
+			{Day: today, UserID: "user1", Domain: "example.com", Created: 2, Redirects: 2},
+			{Day: today, UserID: "user2", Domain: "example.com", Created: 1},
+			{Day: today, UserID: "user2", Domain: "go.dev", Redirects: 5},
+		}, points)
+
+		points, err = r.GetTimeSeries(ctx, from, from.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Len(t, points, 1)
+	})
+
+	t.Run("delete_by_domains", func(t *testing.T) {
+		domains, err := r.GetDomains(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"example.com", "go.dev"}, domains)
+
+		deleted, err := r.DeleteURLSByDomains(ctx, []string{"go.dev"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"4"}, deleted)
+		_, err = r.GetURL(ctx, "4")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+
+		domains, err = r.GetDomains(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"example.com"}, domains)
+	})
+
+	t.Run("get_urls", func(t *testing.T) {
+		page, err := r.GetURLS(ctx, "user1", models.URLFilter{IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.URLRecord{
+			{ShortURL: "1", OriginalURL: "http://example.com/1", Deleted: true},
+			{ShortURL: "2", OriginalURL: "http://example.com/2"},
+		}, page.URLS)
+
+		page, err = r.GetURLS(ctx, "user1", models.URLFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, []models.URLRecord{{ShortURL: "2", OriginalURL: "http://example.com/2"}}, page.URLS)
+	})
+
+	t.Run("threats", func(t *testing.T) {
+		require.NoError(t, r.SetThreats(ctx, map[string]Threat{
+			"2": {URL: "http://example.com/2", Type: "MALWARE"},
+			"3": {URL: "http://example.com/3", Type: "PHISHING"},
+		}))
+		require.NoError(t, r.SetThreats(ctx, map[string]Threat{"3": {URL: "http://example.com/3"}}))
+
+		url, err := r.GetURL(ctx, "2")
+		require.NoError(t, err)
+		assert.Equal(t, "MALWARE", url.Threat)
+
+		var scanned []URLRecord
+		require.NoError(t, r.ScanURLS(ctx, func(record URLRecord) error {
+			scanned = append(scanned, record)
+			return nil
+		}))
+		require.Len(t, scanned, 2)
+		assert.Equal(t, "2", scanned[0].ID)
+		assert.Equal(t, "MALWARE", scanned[0].Threat)
+		assert.Equal(t, "3", scanned[1].ID)
+		assert.Empty(t, scanned[1].Threat)
+	})
+
+	t.Run("claim_click", func(t *testing.T) {
+		testClaimClick(t, r)
+	})
+
+	t.Run("update_url", func(t *testing.T) {
+		testUpdateURL(t, r)
+	})
+
+	t.Run("update_metadata", func(t *testing.T) {
+		testUpdateMetadata(t, r)
+	})
+
+	t.Run("get_urls_page", func(t *testing.T) {
+		testGetURLSPage(t, r)
+	})
+
+	t.Run("scan_user_urls", func(t *testing.T) {
+		testScanUserURLS(t, r)
+	})
+
+	t.Run("create_urls_conflict", func(t *testing.T) {
+		testCreateURLSConflict(t, r)
+	})
+}
+
+func TestBackfillDomainsSQLite(t *testing.T) {
+	ctx := context.Background()
+	r := newSQLiteRepository(t)
+
+	urls := []URLRecord{{ID: "other", URL: "http://Other.example.org/page", UserID: "user1"}}
+	for i := 0; i < backfillBatchSize+10; i++ {
+		urls = append(urls, URLRecord{ID: fmt.Sprintf("url%d", i), URL: fmt.Sprintf("http://example.com/%d", i), UserID: "user1"})
+	}
+	require.NoError(t, r.CreateURLS(ctx, urls))
+	_, err := r.db.Exec("UPDATE shortener SET domain = ''")
+	require.NoError(t, err)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, backfillDomains(ctx, tx, r.dialect))
+	require.NoError(t, tx.Commit())
+
+	counts := make(map[string]int)
+	rows, err := r.db.Query("SELECT domain, COUNT(*) FROM shortener GROUP BY domain")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var domain string
+		var count int
+		require.NoError(t, rows.Scan(&domain, &count))
+		counts[domain] = count
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, map[string]int{"example.com": backfillBatchSize + 10, "other.example.org": 1}, counts)
+}
@@ -1,19 +1,97 @@
 package repository
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/rutkin/url-shortener/internal/app/logger"
 	"go.uber.org/zap"
 )
 
+// operations of file records, file is a log of operations applied in order
+const (
+	fileOpCreate    = ""
+	fileOpDelete    = "delete"
+	fileOpRedirects = "redirects"
+	fileOpThreat    = "threat"
+	fileOpClick     = "click"
+	fileOpUpdate    = "update"
+	fileOpMetadata  = "metadata"
+)
+
+// file record, ID and URL fields are read from files written by previous versions
 type urlRecord struct {
-	ShortURL string `json:"shortURL"`
-	LongURL  string `json:"longURL"`
-	UserID   string `json:"userID"`
+	Op           string    `json:"op,omitempty"`
+	ShortURL     string    `json:"shortURL"`
+	LongURL      string    `json:"longURL,omitempty"`
+	UserID       string    `json:"userID,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Redirects    int64     `json:"redirects,omitempty"`
+	Day          string    `json:"day,omitempty"`
+	Threat       string    `json:"threat,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	Preview      bool      `json:"preview,omitempty"`
+	RedirectType int       `json:"redirectType,omitempty"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	MaxClicks    int       `json:"maxClicks,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Notes        string    `json:"notes,omitempty"`
+	ID           string    `json:"ID,omitempty"`
+	URL          string    `json:"URL,omitempty"`
+}
+
+// decode one json value of file, previous versions stored batches as arrays
+func decodeFileRecords(raw json.RawMessage) ([]urlRecord, error) {
+	var records []urlRecord
+	if len(raw) > 0 && raw[0] == '[' {
+		err := json.Unmarshal(raw, &records)
+		return records, err
+	}
+	var record urlRecord
+	err := json.Unmarshal(raw, &record)
+	return append(records, record), err
+}
+
+func (r *inMemoryRepository) applyFileRecord(record urlRecord) {
+	if record.ShortURL == "" {
+		record.ShortURL = record.ID
+	}
+	if record.LongURL == "" {
+		record.LongURL = record.URL
+	}
+	switch record.Op {
+	case fileOpCreate:
+		r.urls[record.ShortURL] = newURLValue(URLRecord{URL: record.LongURL, UserID: record.UserID, CreatedAt: record.CreatedAt,
+			Title: record.Title, Preview: record.Preview, RedirectType: record.RedirectType, PasswordHash: record.PasswordHash,
+			MaxClicks: record.MaxClicks, Tags: record.Tags, Notes: record.Notes})
+	case fileOpDelete:
+		if url, ok := r.urls[record.ShortURL]; ok {
+			url.deleted = true
+			r.urls[record.ShortURL] = url
+		}
+	case fileOpRedirects:
+		r.addRedirects(record.ShortURL, record.Day, record.Redirects)
+	case fileOpClick:
+		if url, ok := r.urls[record.ShortURL]; ok {
+			url.clicks++
+			r.urls[record.ShortURL] = url
+		}
+	case fileOpUpdate:
+		r.retarget(record.ShortURL, record.UserID, record.LongURL, record.CreatedAt)
+	case fileOpMetadata:
+		r.applyMetadata(record.ShortURL, URLMetadata{Title: record.Title, Tags: record.Tags, Notes: record.Notes})
+	case fileOpThreat:
+		// threat records of previous versions have no checked url
+		if url, ok := r.urls[record.ShortURL]; ok && record.LongURL == "" {
+			record.LongURL = url.longURL
+		}
+		r.setThreat(record.ShortURL, Threat{URL: record.LongURL, Type: record.Threat})
+	}
 }
 
 // create new instance of file repository
@@ -26,49 +104,169 @@ func NewInFileRepository(filename string) (*inFileRepository, error) {
 		return nil, err
 	}
 
-	urls := make(map[string]urlValue)
+	memory := NewInMemoryRepository()
 	decoder := json.NewDecoder(f)
 	for {
-		var record urlRecord
-		err = decoder.Decode(&record)
+		var raw json.RawMessage
+		err = decoder.Decode(&raw)
 		if errors.Is(err, io.EOF) {
 			break
 		}
-
 		if err != nil {
 			logger.Log.Error("Failed to decode url record", zap.String("error", err.Error()))
+			break
 		}
 
-		urls[record.ShortURL] = urlValue{longURL: record.LongURL, userID: record.UserID}
+		records, err := decodeFileRecords(raw)
+		if err != nil {
+			logger.Log.Error("Failed to decode url record", zap.String("error", err.Error()))
+			continue
+		}
+		for _, record := range records {
+			memory.applyFileRecord(record)
+		}
 	}
 
-	return &inFileRepository{inMemoryRepository: &inMemoryRepository{urls: urls}, file: f, encoder: json.NewEncoder(f)}, nil
+	return &inFileRepository{inMemoryRepository: memory, file: f, encoder: json.NewEncoder(f)}, nil
 }
 
 type inFileRepository struct {
 	*inMemoryRepository
 	file    *os.File
 	encoder *json.Encoder
+	writeMu sync.Mutex
+}
+
+// append records to file
+func (r *inFileRepository) write(records ...urlRecord) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	for _, record := range records {
+		if err := r.encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check storage file still exists and is writable
+func (r *inFileRepository) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.file.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func newFileRecord(record URLRecord) urlRecord {
+	return urlRecord{ShortURL: record.ID, LongURL: record.URL, UserID: record.UserID, CreatedAt: createdAt(record),
+		Title: record.Title, Preview: record.Preview, RedirectType: record.RedirectType, PasswordHash: record.PasswordHash,
+		MaxClicks: record.MaxClicks, Tags: record.Tags, Notes: record.Notes}
 }
 
 // store urls in file
-func (r *inFileRepository) CreateURLS(urls []URLRecord) error {
-	err := r.inMemoryRepository.CreateURLS(urls)
+func (r *inFileRepository) CreateURLS(ctx context.Context, urls []URLRecord) error {
+	records := make([]urlRecord, 0, len(urls))
+	stored := make([]URLRecord, 0, len(urls))
+	for _, url := range urls {
+		record := newFileRecord(url)
+		url.CreatedAt = record.CreatedAt
+		records = append(records, record)
+		stored = append(stored, url)
+	}
+	err := r.inMemoryRepository.CreateURLS(ctx, stored)
 	if err != nil {
 		return err
 	}
-	r.encoder.Encode(urls)
-	return nil
+	return r.write(records...)
 }
 
 // store url in file
-func (r *inFileRepository) CreateURL(urlRecord URLRecord) error {
-	err := r.inMemoryRepository.CreateURL(urlRecord)
+func (r *inFileRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
+	record := newFileRecord(urlRecord)
+	urlRecord.CreatedAt = record.CreatedAt
+	err := r.inMemoryRepository.CreateURL(ctx, urlRecord)
+	if err != nil {
+		return err
+	}
+
+	return r.write(record)
+}
+
+// delete urls of user and store deletion in file
+func (r *inFileRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	deleted := r.inMemoryRepository.deleteURLS(urls, userID)
+	records := make([]urlRecord, 0, len(deleted))
+	for _, id := range deleted {
+		records = append(records, urlRecord{Op: fileOpDelete, ShortURL: id})
+	}
+	return r.write(records...)
+}
+
+// delete urls of domains and store deletion in file
+func (r *inFileRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	deleted := r.inMemoryRepository.deleteURLSByDomains(domains)
+	records := make([]urlRecord, 0, len(deleted))
+	for _, id := range deleted {
+		records = append(records, urlRecord{Op: fileOpDelete, ShortURL: id})
+	}
+	return deleted, r.write(records...)
+}
+
+// add redirects and store them in file
+func (r *inFileRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error {
+	err := r.inMemoryRepository.AddRedirects(ctx, redirects)
+	if err != nil {
+		return err
+	}
+	records := make([]urlRecord, 0, len(redirects))
+	for key, count := range redirects {
+		records = append(records, urlRecord{Op: fileOpRedirects, ShortURL: key.ID, Day: key.Day, Redirects: count})
+	}
+	return r.write(records...)
+}
+
+// set threats and store set ones in file with checked url, so url changed before threat record is kept on replay
+func (r *inFileRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	ids := r.inMemoryRepository.setThreats(threats)
+	records := make([]urlRecord, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, urlRecord{Op: fileOpThreat, ShortURL: id, LongURL: threats[id].URL, Threat: threats[id].Type})
+	}
+	return r.write(records...)
+}
+
+// use click and store it in file
+func (r *inFileRepository) ClaimClick(ctx context.Context, id string) error {
+	if err := r.inMemoryRepository.claimClick(id); err != nil {
+		return err
+	}
+	return r.write(urlRecord{Op: fileOpClick, ShortURL: id})
+}
+
+// change url and store revision in file
+func (r *inFileRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	revision, err := r.inMemoryRepository.updateURL(id, userID, url, time.Now().UTC())
 	if err != nil {
 		return err
 	}
+	return r.write(urlRecord{Op: fileOpUpdate, ShortURL: id, LongURL: url, UserID: userID, CreatedAt: revision.CreatedAt})
+}
 
-	return r.encoder.Encode(urlRecord)
+// change metadata and store all metadata of url in file
+func (r *inFileRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	record, err := r.inMemoryRepository.updateMetadata(id, userID, update)
+	if err != nil {
+		return URLRecord{}, err
+	}
+	err = r.write(urlRecord{Op: fileOpMetadata, ShortURL: id, Title: record.Title, Tags: record.Tags, Notes: record.Notes})
+	if err != nil {
+		return URLRecord{}, err
+	}
+	return record, nil
 }
 
 // close file
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInFileRepository(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+
+	require.NoError(t, r.CreateURLS(ctx, []URLRecord{
+		{ID: "1", URL: "http://example.com/1", UserID: "user1"},
+		{ID: "2", URL: "http://example.com/2", UserID: "user1"},
+	}))
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "3", URL: "http://go.dev", UserID: "user2", Title: "Go", Preview: true}))
+	require.NoError(t, r.DeleteURLS(ctx, []string{"1", "3"}, "user1"))
+	today := time.Now().UTC().Format(models.DayLayout)
+	require.NoError(t, r.AddRedirects(ctx, map[RedirectKey]int64{{"2", today}: 2}))
+
+	t.Run("persists_after_reopen", func(t *testing.T) {
+		require.NoError(t, r.Close())
+		r, err = NewInFileRepository(filename)
+		require.NoError(t, err)
+
+		_, err := r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+		url, err := r.GetURL(ctx, "3")
+		require.NoError(t, err)
+		assert.Equal(t, "http://go.dev", url.URL)
+		assert.Equal(t, "Go", url.Title)
+		assert.True(t, url.Preview)
+
+		stats, err := r.GetStats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, stats.URLS)
+		assert.Equal(t, 1, stats.DeletedURLS)
+		assert.Equal(t, 3, stats.CreatedLastDay)
+		assert.Equal(t, int64(2), stats.Redirects)
+
+		from := time.Now().UTC().Truncate(24 * time.Hour)
+		points, err := r.GetTimeSeries(ctx, from, from.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []models.TimeSeriesPoint{
+			{Day: today, UserID: "user1", Domain: "example.com", Created: 2, Redirects: 2},
+			{Day: today, UserID: "user2", Domain: "go.dev", Created: 1},
+		}, points)
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		assert.NoError(t, r.Ping(ctx))
+
+		require.NoError(t, os.Remove(filename))
+		assert.ErrorIs(t, r.Ping(ctx), os.ErrNotExist)
+	})
+
+	require.NoError(t, r.Close())
+}
+
+func TestInFileRepositoryClaimClick(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+	testClaimClick(t, r)
+	require.NoError(t, r.Close())
+
+	r, err = NewInFileRepository(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.ErrorIs(t, r.ClaimClick(context.Background(), "limited"), ErrURLExhausted)
+}
+
+func TestInFileRepositoryUpdateMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+	testUpdateMetadata(t, r)
+	require.NoError(t, r.Close())
+
+	r, err = NewInFileRepository(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	page, err := r.GetURLS(context.Background(), "user3", models.URLFilter{Tag: "docs"})
+	require.NoError(t, err)
+	assert.Equal(t, []models.URLRecord{{ShortURL: "described", OriginalURL: "http://example.com/described", Title: "Docs",
+		Tags: []string{"docs", "go-lang"}, Notes: "third"}}, page.URLS)
+}
+
+func TestInFileRepositoryGetURLSPage(t *testing.T) {
+	r, err := NewInFileRepository(filepath.Join(t.TempDir(), "shortener.json"))
+	require.NoError(t, err)
+	defer r.Close()
+	testGetURLSPage(t, r)
+}
+
+func TestInFileRepositoryScanUserURLS(t *testing.T) {
+	r, err := NewInFileRepository(filepath.Join(t.TempDir(), "shortener.json"))
+	require.NoError(t, err)
+	defer r.Close()
+	testScanUserURLS(t, r)
+}
+
+func TestInFileRepositoryUpdateURL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+	testUpdateURL(t, r)
+	require.NoError(t, r.Close())
+
+	r, err = NewInFileRepository(filename)
+	require.NoError(t, err)
+	defer r.Close()
+	url, err := r.GetURL(context.Background(), "retargeted")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/newer", url.URL)
+	assert.Equal(t, "PHISHING", url.Threat)
+	revisions, err := r.GetRevisions(context.Background(), "retargeted")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 2)
+}
+
+func TestInFileRepositoryStaleThreat(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	// threat of old url is written after update, threat without url is written by previous versions
+	log := `{"shortURL":"1","longURL":"http://example.com/old","userID":"user1"}
+{"op":"update","shortURL":"1","longURL":"http://example.com/new","userID":"user1"}
+{"op":"threat","shortURL":"1","longURL":"http://example.com/old","threat":"MALWARE"}
+{"shortURL":"2","longURL":"http://example.com/2","userID":"user1"}
+{"op":"threat","shortURL":"2","threat":"PHISHING"}
+`
+	require.NoError(t, os.WriteFile(filename, []byte(log), 0666))
+
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+	defer r.Close()
+
+	url, err := r.GetURL(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/new", url.URL)
+	assert.Empty(t, url.Threat)
+	url, err = r.GetURL(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "PHISHING", url.Threat)
+}
+
+func TestInFileRepositoryLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "shortener.json")
+	legacy := `{"ID":"1","URL":"http://example.com/1","UserID":"user1"}
+[{"ID":"2","URL":"http://example.com/2","UserID":"user2"}]
+`
+	require.NoError(t, os.WriteFile(filename, []byte(legacy), 0666))
+
+	r, err := NewInFileRepository(filename)
+	require.NoError(t, err)
+	defer r.Close()
+
+	url, err := r.GetURL(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/1", url.URL)
+	url, err = r.GetURL(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/2", url.URL)
+}
+
+func TestInFileRepositoryCreateURLSConflict(t *testing.T) {
+	r, err := NewInFileRepository(filepath.Join(t.TempDir(), "shortener.json"))
+	require.NoError(t, err)
+	defer r.Close()
+	testCreateURLSConflict(t, r)
+}
@@ -1,81 +1,434 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/rutkin/url-shortener/internal/app/models"
 )
 
-var errURLNotFound = errors.New("URL not found")
-var errNotImplemented = errors.New("not implemented")
+var ErrURLNotFound = errors.New("URL not found")
 
 // create new instance of repository in memory
 func NewInMemoryRepository() *inMemoryRepository {
 	res := new(inMemoryRepository)
 	res.urls = make(map[string]urlValue)
+	res.revisions = make(map[string][]models.URLRevision)
 
 	return res
 }
 
 type urlValue struct {
-	longURL string
-	userID  string
+	longURL   string
+	userID    string
+	createdAt time.Time
+	deleted   bool
+	redirects int64
+	// redirects by day
+	dailyRedirects map[string]int64
+	threat         string
+	title          string
+	preview        bool
+	redirectType   int
+	passwordHash   string
+	maxClicks      int
+	clicks         int
+	tags           []string
+	notes          string
+}
+
+func newURLValue(record URLRecord) urlValue {
+	return urlValue{longURL: record.URL, userID: record.UserID, createdAt: createdAt(record), title: record.Title, preview: record.Preview,
+		redirectType: record.RedirectType, passwordHash: record.PasswordHash, maxClicks: record.MaxClicks, clicks: record.Clicks,
+		tags: record.Tags, notes: record.Notes}
+}
+
+func (v urlValue) record(id string) URLRecord {
+	return URLRecord{ID: id, URL: v.longURL, UserID: v.userID, CreatedAt: v.createdAt, Threat: v.threat, Title: v.title, Preview: v.preview,
+		RedirectType: v.redirectType, PasswordHash: v.passwordHash, MaxClicks: v.maxClicks, Clicks: v.clicks,
+		Tags: v.tags, Notes: v.notes}
 }
 
 type inMemoryRepository struct {
-	urls map[string]urlValue // [shortURL, (longURL, userID)]
-	mu   sync.RWMutex
+	urls      map[string]urlValue // [shortURL, (longURL, userID)]
+	revisions map[string][]models.URLRevision
+	mu        sync.RWMutex
 }
 
 // store urls in memory
-func (r *inMemoryRepository) CreateURLS(urlRecords []URLRecord) error {
+func (r *inMemoryRepository) CreateURLS(ctx context.Context, urlRecords []URLRecord) error {
 	r.mu.Lock()
+	defer r.mu.Unlock()
+	// batch is created only when none of its urls exists
+	batch := make(map[string]bool, len(urlRecords))
 	for _, record := range urlRecords {
-		r.urls[record.ID] = urlValue{longURL: record.URL, userID: record.UserID}
+		if _, ok := r.urls[record.ID]; ok || batch[record.ID] {
+			return ErrConflict
+		}
+		batch[record.ID] = true
+	}
+	for _, record := range urlRecords {
+		r.urls[record.ID] = newURLValue(record)
 	}
-	r.mu.Unlock()
 	return nil
 }
 
-// store url in memory
-func (r *inMemoryRepository) CreateURL(urlRecord URLRecord) error {
+// store url in memory, existing url is kept
+func (r *inMemoryRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
 	r.mu.Lock()
-	r.urls[urlRecord.ID] = urlValue{longURL: urlRecord.URL, userID: urlRecord.UserID}
-	r.mu.Unlock()
-
+	defer r.mu.Unlock()
+	if _, ok := r.urls[urlRecord.ID]; ok {
+		return ErrConflict
+	}
+	r.urls[urlRecord.ID] = newURLValue(urlRecord)
 	return nil
 }
 
 // get url from memoty
-func (r *inMemoryRepository) GetURL(id string) (string, error) {
+func (r *inMemoryRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	url, ok := r.urls[id]
 	if !ok {
-		return "", errURLNotFound
+		return URLRecord{}, ErrURLNotFound
+	}
+	if url.deleted {
+		return URLRecord{}, ErrURLDeleted
 	}
 
-	return url.longURL, nil
+	return url.record(id), nil
 }
 
 // get urls from memory
-func (r *inMemoryRepository) GetURLS(userID string) ([]models.URLRecord, error) {
-	return nil, nil
+func (r *inMemoryRepository) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error) {
+	r.mu.RLock()
+	var urls []listedURL
+	for id, url := range r.urls {
+		listed := listedURL{url.record(id), url.deleted}
+		if url.userID == userID && matchesFilter(listed, filter) {
+			urls = append(urls, listed)
+		}
+	}
+	r.mu.RUnlock()
+	return paginate(urls, filter)
+}
+
+// call fn for all user urls matching filter, urls are copied by chunks, so fn is called without lock
+func (r *inMemoryRepository) ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error {
+	collect := func(add func(listedURL)) error {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		for id, url := range r.urls {
+			if url.userID == userID {
+				add(listedURL{url.record(id), url.deleted})
+			}
+		}
+		return nil
+	}
+	load := func(ids []string) ([]listedURL, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		urls := make([]listedURL, 0, len(ids))
+		for _, id := range ids {
+			if url, ok := r.urls[id]; ok {
+				urls = append(urls, listedURL{url.record(id), url.deleted})
+			}
+		}
+		return urls, nil
+	}
+	return scanUserURLS(ctx, filter, collect, load, fn)
+}
+
+// mark urls of user as deleted, returns ids of deleted urls
+func (r *inMemoryRepository) deleteURLS(urls []string, userID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted []string
+	for _, id := range urls {
+		url, ok := r.urls[id]
+		if !ok || url.userID != userID || url.deleted {
+			continue
+		}
+		url.deleted = true
+		r.urls[id] = url
+		deleted = append(deleted, id)
+	}
+	return deleted
 }
 
 // delete urls from memory
-func (r *inMemoryRepository) DeleteURLS(urls []string, userID string) error {
-	return errNotImplemented
+func (r *inMemoryRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	r.deleteURLS(urls, userID)
+	return nil
+}
+
+// add redirects of stored urls
+func (r *inMemoryRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, count := range redirects {
+		r.addRedirects(key.ID, key.Day, count)
+	}
+	return nil
+}
+
+// add redirects of url on day, empty day counts only in total, caller holds lock
+func (r *inMemoryRepository) addRedirects(id, day string, count int64) {
+	url, ok := r.urls[id]
+	if !ok {
+		return
+	}
+	url.redirects += count
+	if day != "" {
+		if url.dailyRedirects == nil {
+			url.dailyRedirects = make(map[string]int64)
+		}
+		url.dailyRedirects[day] += count
+	}
+	r.urls[id] = url
+}
+
+// get daily counts
+func (r *inMemoryRepository) GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	collector := newTimeSeriesCollector(from, to)
+	for _, url := range r.urls {
+		collector.add(url.longURL, url.userID, url.createdAt, url.dailyRedirects)
+	}
+	return collector.result(), nil
 }
 
 // get stats
-func (r *inMemoryRepository) GetStats() (models.StatRecord, error) {
-	userSet := make(map[string]bool)
-	for _, urlValue := range r.urls {
-		userSet[urlValue.userID] = true
+func (r *inMemoryRepository) GetStats(ctx context.Context) (models.StatRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	collector := newStatsCollector(time.Now())
+	for _, url := range r.urls {
+		collector.add(url.longURL, url.userID, url.createdAt, url.deleted, url.redirects)
 	}
-	return models.StatRecord{URLS: len(r.urls), Users: len(userSet)}, nil
+	return collector.result(), nil
+}
+
+// get distinct domains of not deleted urls
+func (r *inMemoryRepository) GetDomains(ctx context.Context) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var domains []string
+	for _, url := range r.urls {
+		domain := domainOf(url.longURL)
+		if url.deleted || domain == "" || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// mark urls of domains as deleted, returns ids of deleted urls
+func (r *inMemoryRepository) deleteURLSByDomains(domains []string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	blocked := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		blocked[domain] = true
+	}
+	var deleted []string
+	for id, url := range r.urls {
+		if url.deleted || !blocked[domainOf(url.longURL)] {
+			continue
+		}
+		url.deleted = true
+		r.urls[id] = url
+		deleted = append(deleted, id)
+	}
+	return deleted
+}
+
+// delete urls of domains
+func (r *inMemoryRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	return r.deleteURLSByDomains(domains), nil
+}
+
+// call fn for copies of not deleted urls, so fn can use repository
+func (r *inMemoryRepository) ScanURLS(ctx context.Context, fn func(URLRecord) error) error {
+	r.mu.RLock()
+	records := make([]URLRecord, 0, len(r.urls))
+	for id, url := range r.urls {
+		if !url.deleted {
+			records = append(records, url.record(id))
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set threats of stored urls
+func (r *inMemoryRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	r.setThreats(threats)
+	return nil
+}
+
+// set threats under lock, returns ids of urls with set threat
+func (r *inMemoryRepository) setThreats(threats map[string]Threat) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for id, threat := range threats {
+		if r.setThreat(id, threat) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// set threat of url still pointing to checked destination
+func (r *inMemoryRepository) setThreat(id string, threat Threat) bool {
+	url, ok := r.urls[id]
+	if !ok || url.longURL != threat.URL {
+		return false
+	}
+	url.threat = threat.Type
+	r.urls[id] = url
+	return true
+}
+
+// use click of url under lock
+func (r *inMemoryRepository) claimClick(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	url, ok := r.urls[id]
+	if !ok {
+		return ErrURLNotFound
+	}
+	if url.deleted {
+		return ErrURLDeleted
+	}
+	if url.clicks >= url.maxClicks {
+		return ErrURLExhausted
+	}
+	url.clicks++
+	r.urls[id] = url
+	return nil
+}
+
+// use click of url
+func (r *inMemoryRepository) ClaimClick(ctx context.Context, id string) error {
+	return r.claimClick(id)
+}
+
+// check url exists and is owned by user, caller holds lock
+func (r *inMemoryRepository) checkOwner(id string, userID string) error {
+	value, ok := r.urls[id]
+	if !ok {
+		return ErrURLNotFound
+	}
+	if value.deleted {
+		return ErrURLDeleted
+	}
+	if value.userID != userID {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// change url of user under lock, returns recorded revision
+func (r *inMemoryRepository) updateURL(id string, userID string, url string, changedAt time.Time) (models.URLRevision, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkOwner(id, userID); err != nil {
+		return models.URLRevision{}, err
+	}
+	return r.retarget(id, userID, url, changedAt), nil
+}
+
+// change metadata of user url under lock, returns changed url
+func (r *inMemoryRepository) updateMetadata(id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkOwner(id, userID); err != nil {
+		return URLRecord{}, err
+	}
+	value := r.urls[id]
+	if update.Title != nil {
+		value.title = *update.Title
+	}
+	if update.Tags != nil {
+		value.tags = *update.Tags
+	}
+	if update.Notes != nil {
+		value.notes = *update.Notes
+	}
+	r.urls[id] = value
+	return value.record(id), nil
+}
+
+// replace metadata, caller holds lock
+func (r *inMemoryRepository) applyMetadata(id string, metadata URLMetadata) {
+	if value, ok := r.urls[id]; ok {
+		value.title = metadata.Title
+		value.tags = metadata.Tags
+		value.notes = metadata.Notes
+		r.urls[id] = value
+	}
+}
+
+// change metadata
+func (r *inMemoryRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	return r.updateMetadata(id, userID, update)
+}
+
+// change url and record revision, caller holds lock
+func (r *inMemoryRepository) retarget(id string, userID string, url string, changedAt time.Time) models.URLRevision {
+	value, ok := r.urls[id]
+	if !ok {
+		return models.URLRevision{}
+	}
+	revision := models.URLRevision{
+		Revision:  len(r.revisions[id]) + 1,
+		OldURL:    value.longURL,
+		NewURL:    url,
+		UserID:    userID,
+		CreatedAt: changedAt,
+	}
+	r.revisions[id] = append(r.revisions[id], revision)
+	value.longURL = url
+	value.threat = ""
+	r.urls[id] = value
+	return revision
+}
+
+// change url
+func (r *inMemoryRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	_, err := r.updateURL(id, userID, url, time.Now().UTC())
+	return err
+}
+
+// get copy of url revisions
+func (r *inMemoryRepository) GetRevisions(ctx context.Context, id string) ([]models.URLRevision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]models.URLRevision(nil), r.revisions[id]...), nil
+}
+
+// memory is always reachable
+func (r *inMemoryRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
 }
 
 // close
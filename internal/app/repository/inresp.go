@@ -0,0 +1,752 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/resp"
+	"go.uber.org/zap"
+)
+
+// keys layout:
+// shortener:url:<shortURL> - hash with url, user, deleted, created, redirects, threat, title, preview
+// redirect_type, password_hash, max_clicks, clicks, revision, tags (comma separated) and notes fields
+// shortener:user:<userID> - set of user short urls
+// shortener:redirects:<shortURL> - hash of redirects by day
+// shortener:revisions:<shortURL> - hash of revision json by revision number
+// shortener:urls, shortener:users - sets of all short urls and users
+const (
+	respURLPrefix      = "shortener:url:"
+	respUserPrefix     = "shortener:user:"
+	respDailyPrefix    = "shortener:redirects:"
+	respRevisionPrefix = "shortener:revisions:"
+	respURLSKey        = "shortener:urls"
+	respUsersKey       = "shortener:users"
+)
+
+// create new instance of repository on RESP (Redis protocol) server
+func NewInRESPRepository(addr string) (*inRESPRepository, error) {
+	client, err := resp.Dial(addr)
+	if err != nil {
+		logger.Log.Error("Failed to connect to resp server", zap.String("address", addr), zap.String("error", err.Error()))
+		return nil, err
+	}
+	return &inRESPRepository{client}, nil
+}
+
+type inRESPRepository struct {
+	client *resp.Client
+}
+
+func respURLKey(id string) string {
+	return respURLPrefix + id
+}
+
+func respUserKey(userID string) string {
+	return respUserPrefix + userID
+}
+
+func respDailyKey(id string) string {
+	return respDailyPrefix + id
+}
+
+func respRevisionsKey(id string) string {
+	return respRevisionPrefix + id
+}
+
+func respBool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func respCreateCommands(urlRecord URLRecord) [][]string {
+	return [][]string{
+		{"HSET", respURLKey(urlRecord.ID), "url", urlRecord.URL, "user", urlRecord.UserID, "deleted", "0", "created", createdAt(urlRecord).Format(time.RFC3339Nano),
+			"title", urlRecord.Title, "preview", respBool(urlRecord.Preview), "redirect_type", strconv.Itoa(urlRecord.RedirectType),
+			"password_hash", urlRecord.PasswordHash, "max_clicks", strconv.Itoa(urlRecord.MaxClicks), "clicks", "0",
+			"tags", strings.Join(urlRecord.Tags, ","), "notes", urlRecord.Notes},
+		{"SADD", respUserKey(urlRecord.UserID), urlRecord.ID},
+		{"SADD", respURLSKey, urlRecord.ID},
+		{"SADD", respUsersKey, urlRecord.UserID},
+	}
+}
+
+// store urls in transaction watching their keys, batch is created only when none of its urls exists
+func (r *inRESPRepository) CreateURLS(ctx context.Context, urls []URLRecord) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(urls))
+	batch := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if batch[url.ID] {
+			return ErrConflict
+		}
+		batch[url.ID] = true
+		keys = append(keys, respURLKey(url.ID))
+	}
+
+	err := r.client.Watch(ctx, keys, func(tx *resp.Tx) error {
+		reply, err := tx.Do(append([]string{"EXISTS"}, keys...)...)
+		if err != nil {
+			return err
+		}
+		existing, err := resp.Int(reply)
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrConflict
+		}
+		var create [][]string
+		for _, url := range urls {
+			create = append(create, respCreateCommands(url)...)
+		}
+		replies, err := tx.Exec(create)
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrConflict) {
+		logger.FromContext(ctx).Error("Failed to create urls", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// store url, conflict when short url already exists
+func (r *inRESPRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
+	return r.CreateURLS(ctx, []URLRecord{urlRecord})
+}
+
+// get url from resp server
+func (r *inRESPRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
+	reply, err := r.client.Do(ctx, respGetCommand(id)...)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get url", zap.String("error", err.Error()))
+		return URLRecord{}, err
+	}
+	values, err := resp.Strings(reply)
+	if err != nil {
+		return URLRecord{}, err
+	}
+	record, deleted, ok := respRecord(id, values)
+	if !ok {
+		return URLRecord{}, ErrURLNotFound
+	}
+	if deleted {
+		return URLRecord{}, ErrURLDeleted
+	}
+	return record, nil
+}
+
+// command to get url record fields
+func respGetCommand(id string) []string {
+	return []string{"HMGET", respURLKey(id), "url", "user", "deleted", "created", "threat", "title", "preview", "redirect_type", "password_hash",
+		"max_clicks", "clicks", "tags", "notes"}
+}
+
+// record from reply of respGetCommand
+func respRecord(id string, values []string) (record URLRecord, deleted bool, ok bool) {
+	if len(values) != 13 || values[0] == "" {
+		return URLRecord{}, false, false
+	}
+	created, _ := time.Parse(time.RFC3339Nano, values[3])
+	redirectType, _ := strconv.Atoi(values[7])
+	maxClicks, _ := strconv.Atoi(values[9])
+	clicks, _ := strconv.Atoi(values[10])
+	return URLRecord{ID: id, URL: values[0], UserID: values[1], CreatedAt: created, Threat: values[4],
+		Title: values[5], Preview: values[6] == "1", RedirectType: redirectType, PasswordHash: values[8],
+		MaxClicks: maxClicks, Clicks: clicks, Tags: splitTags(values[11]), Notes: values[12]}, values[2] == "1", true
+}
+
+// get urls of user from resp server, all user urls are read and paginated in memory
+func (r *inRESPRepository) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error) {
+	reply, err := r.client.Do(ctx, "SMEMBERS", respUserKey(userID))
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get user urls", zap.String("error", err.Error()))
+		return models.URLPage{}, err
+	}
+	ids, err := resp.Strings(reply)
+	if err != nil || len(ids) == 0 {
+		return models.URLPage{}, err
+	}
+
+	listed, err := r.getListedURLS(ctx, ids)
+	if err != nil {
+		return models.URLPage{}, err
+	}
+	var urls []listedURL
+	for _, url := range listed {
+		if matchesFilter(url, filter) {
+			urls = append(urls, url)
+		}
+	}
+	return paginate(urls, filter)
+}
+
+// get urls with deletion flag in one pipeline, missing urls are skipped
+func (r *inRESPRepository) getListedURLS(ctx context.Context, ids []string) ([]listedURL, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	cmds := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, respGetCommand(id))
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get urls", zap.String("error", err.Error()))
+		return nil, err
+	}
+
+	urls := make([]listedURL, 0, len(ids))
+	for i, reply := range replies {
+		values, err := resp.Strings(reply)
+		if err != nil {
+			return nil, err
+		}
+		if record, deleted, ok := respRecord(ids[i], values); ok {
+			urls = append(urls, listedURL{record, deleted})
+		}
+	}
+	return urls, nil
+}
+
+// call fn for all user urls matching filter, user set is walked by SSCAN and urls are read by pipelines
+func (r *inRESPRepository) ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error {
+	collect := func(add func(listedURL)) error {
+		// SSCAN can return member more than once
+		seen := make(map[string]bool)
+		cursor := "0"
+		for {
+			reply, err := r.client.Do(ctx, "SSCAN", respUserKey(userID), cursor, "COUNT", strconv.Itoa(scanChunkSize))
+			if err != nil {
+				logger.FromContext(ctx).Error("Failed to scan user urls", zap.String("error", err.Error()))
+				return err
+			}
+			var ids []string
+			cursor, ids, err = respScanReply(reply)
+			if err != nil {
+				return err
+			}
+			unseen := ids[:0]
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					unseen = append(unseen, id)
+				}
+			}
+			urls, err := r.getListedURLS(ctx, unseen)
+			if err != nil {
+				return err
+			}
+			for _, url := range urls {
+				add(url)
+			}
+			if cursor == "0" {
+				return nil
+			}
+		}
+	}
+	load := func(ids []string) ([]listedURL, error) {
+		return r.getListedURLS(ctx, ids)
+	}
+	return scanUserURLS(ctx, filter, collect, load, fn)
+}
+
+// next cursor and members from reply of SSCAN
+func respScanReply(reply interface{}) (string, []string, error) {
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		if err, ok := reply.(resp.Error); ok {
+			return "", nil, err
+		}
+		return "", nil, resp.ErrUnexpectedReply
+	}
+	cursor, _, err := resp.String(parts[0])
+	if err != nil {
+		return "", nil, err
+	}
+	members, err := resp.Strings(parts[1])
+	return cursor, members, err
+}
+
+// mark urls of user as deleted
+func (r *inRESPRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	var check [][]string
+	for _, id := range urls {
+		check = append(check, []string{"SISMEMBER", respUserKey(userID), id})
+	}
+	replies, err := r.client.Pipeline(ctx, check)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to check urls owner", zap.String("error", err.Error()))
+		return err
+	}
+
+	var del [][]string
+	for i, reply := range replies {
+		owned, err := resp.Int(reply)
+		if err != nil {
+			return err
+		}
+		if owned == 1 {
+			del = append(del, []string{"HSET", respURLKey(urls[i]), "deleted", "1"})
+		}
+	}
+	if len(del) == 0 {
+		return nil
+	}
+
+	replies, err = r.client.Pipeline(ctx, del)
+	if err == nil {
+		err = resp.FirstError(replies)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to delete urls", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// get stats, records of all urls are read in one pipeline
+func (r *inRESPRepository) GetStats(ctx context.Context) (models.StatRecord, error) {
+	reply, err := r.client.Do(ctx, "SMEMBERS", respURLSKey)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get stats", zap.String("error", err.Error()))
+		return models.StatRecord{}, err
+	}
+	ids, err := resp.Strings(reply)
+	if err != nil {
+		return models.StatRecord{}, err
+	}
+
+	collector := newStatsCollector(time.Now())
+	if len(ids) == 0 {
+		return collector.result(), nil
+	}
+	cmds := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, []string{"HMGET", respURLKey(id), "url", "user", "deleted", "created", "redirects"})
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get stats", zap.String("error", err.Error()))
+		return models.StatRecord{}, err
+	}
+	for _, reply := range replies {
+		values, err := resp.Strings(reply)
+		if err != nil {
+			return models.StatRecord{}, err
+		}
+		if len(values) != 5 || values[0] == "" {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339Nano, values[3])
+		redirects, _ := strconv.ParseInt(values[4], 10, 64)
+		collector.add(values[0], values[1], created, values[2] == "1", redirects)
+	}
+	return collector.result(), nil
+}
+
+// add redirects in one pipeline
+func (r *inRESPRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error {
+	if len(redirects) == 0 {
+		return nil
+	}
+	cmds := make([][]string, 0, 2*len(redirects))
+	for key, count := range redirects {
+		increment := strconv.FormatInt(count, 10)
+		cmds = append(cmds,
+			[]string{"HINCRBY", respURLKey(key.ID), "redirects", increment},
+			[]string{"HINCRBY", respDailyKey(key.ID), key.Day, increment})
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err == nil {
+		err = resp.FirstError(replies)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to add redirects", zap.String("error", err.Error()))
+		return err
+	}
+	return nil
+}
+
+// get daily counts, records and daily redirects of all urls are read in one pipeline
+func (r *inRESPRepository) GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error) {
+	reply, err := r.client.Do(ctx, "SMEMBERS", respURLSKey)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get time series", zap.String("error", err.Error()))
+		return nil, err
+	}
+	ids, err := resp.Strings(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := newTimeSeriesCollector(from, to)
+	if len(ids) == 0 {
+		return collector.result(), nil
+	}
+	cmds := make([][]string, 0, 2*len(ids))
+	for _, id := range ids {
+		cmds = append(cmds,
+			[]string{"HMGET", respURLKey(id), "url", "user", "created"},
+			[]string{"HGETALL", respDailyKey(id)})
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get time series", zap.String("error", err.Error()))
+		return nil, err
+	}
+	for i := 0; i+1 < len(replies); i += 2 {
+		values, err := resp.Strings(replies[i])
+		if err != nil {
+			return nil, err
+		}
+		daily, err := resp.Strings(replies[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 3 || values[0] == "" {
+			continue
+		}
+		dailyRedirects := make(map[string]int64, len(daily)/2)
+		for j := 0; j+1 < len(daily); j += 2 {
+			count, _ := strconv.ParseInt(daily[j+1], 10, 64)
+			dailyRedirects[daily[j]] = count
+		}
+		created, _ := time.Parse(time.RFC3339Nano, values[2])
+		collector.add(values[0], values[1], created, dailyRedirects)
+	}
+	return collector.result(), nil
+}
+
+// read records of all urls in one pipeline, urls are ordered by id
+func (r *inRESPRepository) scanURLS(ctx context.Context, fn func(record URLRecord, deleted bool) error) error {
+	reply, err := r.client.Do(ctx, "SMEMBERS", respURLSKey)
+	if err != nil {
+		return err
+	}
+	ids, err := resp.Strings(reply)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	sort.Strings(ids)
+	cmds := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, respGetCommand(id))
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return err
+	}
+	for i, reply := range replies {
+		values, err := resp.Strings(reply)
+		if err != nil {
+			return err
+		}
+		if record, deleted, ok := respRecord(ids[i], values); ok {
+			if err := fn(record, deleted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// call fn for not deleted urls
+func (r *inRESPRepository) ScanURLS(ctx context.Context, fn func(URLRecord) error) error {
+	err := r.scanURLS(ctx, func(record URLRecord, deleted bool) error {
+		if deleted {
+			return nil
+		}
+		return fn(record)
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to scan urls", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// set threats in transaction watching urls, threat is set only while url points to checked destination
+func (r *inRESPRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	if len(threats) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(threats))
+	keys := make([]string, 0, len(threats))
+	for id := range threats {
+		ids = append(ids, id)
+		keys = append(keys, respURLKey(id))
+	}
+
+	err := r.client.Watch(ctx, keys, func(tx *resp.Tx) error {
+		cmds := make([][]string, 0, len(ids))
+		for _, id := range ids {
+			cmds = append(cmds, []string{"HGET", respURLKey(id), "url"})
+		}
+		replies, err := tx.Pipeline(cmds)
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		if err != nil {
+			return err
+		}
+
+		var set [][]string
+		for i, reply := range replies {
+			url, ok, err := resp.String(reply)
+			if err != nil {
+				return err
+			}
+			if ok && url == threats[ids[i]].URL {
+				set = append(set, []string{"HSET", respURLKey(ids[i]), "threat", threats[ids[i]].Type})
+			}
+		}
+		if len(set) == 0 {
+			return nil
+		}
+		replies, err = tx.Exec(set)
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		return err
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to set threats", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// use click in transaction watching url, click is counted only while clicks are below max clicks
+func (r *inRESPRepository) ClaimClick(ctx context.Context, id string) error {
+	err := r.client.Watch(ctx, []string{respURLKey(id)}, func(tx *resp.Tx) error {
+		reply, err := tx.Do("HMGET", respURLKey(id), "deleted", "max_clicks", "clicks")
+		if err != nil {
+			return err
+		}
+		values, err := resp.Strings(reply)
+		if err != nil {
+			return err
+		}
+		if len(values) != 3 || values[1] == "" {
+			return ErrURLNotFound
+		}
+		if values[0] == "1" {
+			return ErrURLDeleted
+		}
+		maxClicks, _ := strconv.Atoi(values[1])
+		clicks, _ := strconv.Atoi(values[2])
+		if clicks >= maxClicks {
+			return ErrURLExhausted
+		}
+		replies, err := tx.Exec([][]string{{"HINCRBY", respURLKey(id), "clicks", "1"}})
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		return err
+	})
+	if err != nil && !isURLStateError(err) {
+		logger.FromContext(ctx).Error("Failed to claim click", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// url missing, deleted, exhausted or owned by other user, such errors are not logged
+func isURLStateError(err error) bool {
+	return errors.Is(err, ErrURLNotFound) || errors.Is(err, ErrURLDeleted) || errors.Is(err, ErrURLExhausted) ||
+		errors.Is(err, ErrNotOwner)
+}
+
+// get url owned by user and its revision inside transaction
+func respOwnedURL(tx *resp.Tx, id string, userID string) (string, int64, error) {
+	reply, err := tx.Do("HMGET", respURLKey(id), "url", "user", "deleted", "revision")
+	if err != nil {
+		return "", 0, err
+	}
+	values, err := resp.Strings(reply)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(values) != 4 || values[0] == "" {
+		return "", 0, ErrURLNotFound
+	}
+	if values[2] == "1" {
+		return "", 0, ErrURLDeleted
+	}
+	if values[1] != userID {
+		return "", 0, ErrNotOwner
+	}
+	revision, _ := strconv.ParseInt(values[3], 10, 64)
+	return values[0], revision, nil
+}
+
+// change url and add revision in transaction watching url, so owner check and revision number stay valid
+func (r *inRESPRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	err := r.client.Watch(ctx, []string{respURLKey(id)}, func(tx *resp.Tx) error {
+		oldURL, number, err := respOwnedURL(tx, id, userID)
+		if err != nil {
+			return err
+		}
+		number++
+		revision, err := json.Marshal(models.URLRevision{Revision: int(number), OldURL: oldURL, NewURL: url, UserID: userID,
+			CreatedAt: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		replies, err := tx.Exec([][]string{
+			{"HSET", respRevisionsKey(id), strconv.FormatInt(number, 10), string(revision)},
+			{"HSET", respURLKey(id), "url", url, "threat", "", "revision", strconv.FormatInt(number, 10)},
+		})
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		return err
+	})
+	if err != nil && !isURLStateError(err) {
+		logger.FromContext(ctx).Error("Failed to update url", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// change metadata of url in transaction watching url, only given fields are set,
+// so concurrent changes of other fields are kept
+func (r *inRESPRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	var record URLRecord
+	err := r.client.Watch(ctx, []string{respURLKey(id)}, func(tx *resp.Tx) error {
+		if _, _, err := respOwnedURL(tx, id, userID); err != nil {
+			return err
+		}
+		var cmds [][]string
+		command := []string{"HSET", respURLKey(id)}
+		if update.Title != nil {
+			command = append(command, "title", *update.Title)
+		}
+		if update.Tags != nil {
+			command = append(command, "tags", strings.Join(*update.Tags, ","))
+		}
+		if update.Notes != nil {
+			command = append(command, "notes", *update.Notes)
+		}
+		if len(command) > 2 {
+			cmds = append(cmds, command)
+		}
+		replies, err := tx.Exec(append(cmds, respGetCommand(id)))
+		if err == nil {
+			err = resp.FirstError(replies)
+		}
+		if err != nil {
+			return err
+		}
+		values, err := resp.Strings(replies[len(replies)-1])
+		if err != nil {
+			return err
+		}
+		record, _, _ = respRecord(id, values)
+		return nil
+	})
+	if err != nil && !isURLStateError(err) {
+		logger.FromContext(ctx).Error("Failed to update metadata", zap.String("error", err.Error()))
+	}
+	return record, err
+}
+
+// get revisions of url sorted by number
+func (r *inRESPRepository) GetRevisions(ctx context.Context, id string) ([]models.URLRevision, error) {
+	reply, err := r.client.Do(ctx, "HGETALL", respRevisionsKey(id))
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get revisions", zap.String("error", err.Error()))
+		return nil, err
+	}
+	values, err := resp.Strings(reply)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []models.URLRevision
+	for i := 1; i < len(values); i += 2 {
+		var revision models.URLRevision
+		if err := json.Unmarshal([]byte(values[i]), &revision); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	return revisions, nil
+}
+
+// get distinct domains of not deleted urls
+func (r *inRESPRepository) GetDomains(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	var domains []string
+	err := r.scanURLS(ctx, func(record URLRecord, deleted bool) error {
+		domain := domainOf(record.URL)
+		if !deleted && domain != "" && !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get domains", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return domains, nil
+}
+
+// mark urls of domains as deleted
+func (r *inRESPRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	blocked := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		blocked[domain] = true
+	}
+	var deleted []string
+	err := r.scanURLS(ctx, func(record URLRecord, isDeleted bool) error {
+		if !isDeleted && blocked[domainOf(record.URL)] {
+			deleted = append(deleted, record.ID)
+		}
+		return nil
+	})
+	if err != nil || len(deleted) == 0 {
+		return nil, err
+	}
+
+	cmds := make([][]string, 0, len(deleted))
+	for _, id := range deleted {
+		cmds = append(cmds, []string{"HSET", respURLKey(id), "deleted", "1"})
+	}
+	replies, err := r.client.Pipeline(ctx, cmds)
+	if err == nil {
+		err = resp.FirstError(replies)
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to delete urls of domains", zap.String("error", err.Error()))
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// check resp server connection
+func (r *inRESPRepository) Ping(ctx context.Context) error {
+	_, err := r.client.Do(ctx, "PING")
+	return err
+}
+
+// close connections
+func (r *inRESPRepository) Close() error {
+	return r.client.Close()
+}
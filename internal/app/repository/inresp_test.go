@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/resp/resptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInRESPRepository(t *testing.T) {
+	ctx := context.Background()
+	server, err := resptest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	r, err := NewInRESPRepository(server.Addr())
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.NoError(t, r.CreateURLS(ctx, []URLRecord{
+		{ID: "1", URL: "http://example.com/1", UserID: "user1"},
+		{ID: "2", URL: "http://example.com/2", UserID: "user1", Title: "Example", Preview: true, RedirectType: 301},
+	}))
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "3", URL: "http://example.com/3", UserID: "user2"}))
+
+	t.Run("get_url", func(t *testing.T) {
+		url, err := r.GetURL(ctx, "2")
+		require.NoError(t, err)
+		assert.Equal(t, "http://example.com/2", url.URL)
+		assert.Equal(t, "Example", url.Title)
+		assert.True(t, url.Preview)
+		assert.Equal(t, 301, url.RedirectType)
+
+		_, err = r.GetURL(ctx, "unknown")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		err := r.CreateURL(ctx, URLRecord{ID: "1", URL: "http://example.com/1", UserID: "user2"})
+		assert.ErrorIs(t, err, ErrConflict)
+	})
+
+	t.Run("get_urls", func(t *testing.T) {
+		page, err := r.GetURLS(ctx, "user1", models.URLFilter{Order: models.OrderAsc})
+		require.NoError(t, err)
+		assert.Equal(t, []models.URLRecord{
+			{ShortURL: "1", OriginalURL: "http://example.com/1"},
+			{ShortURL: "2", OriginalURL: "http://example.com/2", Title: "Example"},
+		}, page.URLS)
+	})
+
+	t.Run("delete_only_own_urls", func(t *testing.T) {
+		require.NoError(t, r.DeleteURLS(ctx, []string{"1", "3"}, "user1"))
+
+		_, err := r.GetURL(ctx, "1")
+		assert.ErrorIs(t, err, ErrURLDeleted)
+		_, err = r.GetURL(ctx, "3")
+		assert.NoError(t, err)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		today := time.Now().UTC().Format(models.DayLayout)
+		require.NoError(t, r.AddRedirects(ctx, map[RedirectKey]int64{{"2", today}: 3, {"3", today}: 1}))
+
+		stats, err := r.GetStats(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, models.StatRecord{
+			URLS:            3,
+			Users:           2,
+			ActiveURLS:      2,
+			DeletedURLS:     1,
+			CreatedLastDay:  3,
+			CreatedLastWeek: 3,
+			Redirects:       4,
+			TopDomains:      []models.DomainStat{{Domain: "example.com", URLS: 2}},
+		}, stats)
+
+		from := time.Now().UTC().Truncate(24 * time.Hour)
+		points, err := r.GetTimeSeries(ctx, from, from.Add(24*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []models.TimeSeriesPoint{
+			{Day: today, UserID: "user1", Domain: "example.com", Created: 2, Redirects: 3},
+			{Day: today, UserID: "user2", Domain: "example.com", Created: 1, Redirects: 1},
+		}, points)
+	})
+
+	t.Run("claim_click", func(t *testing.T) {
+		testClaimClick(t, r)
+		reply, err := r.client.Do(ctx, "HGETALL", respURLKey("unknown"))
+		require.NoError(t, err)
+		assert.Empty(t, reply)
+	})
+
+	t.Run("claim_click_concurrent", func(t *testing.T) {
+		testClaimClickConcurrent(t, r)
+	})
+
+	t.Run("update_url", func(t *testing.T) {
+		testUpdateURL(t, r)
+	})
+
+	t.Run("update_url_concurrent", func(t *testing.T) {
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "concurrent_update", URL: "http://example.com/0", UserID: "user10"}))
+		var wg sync.WaitGroup
+		for i := 1; i <= 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, r.UpdateURL(ctx, "concurrent_update", "user10", "http://example.com/"+strconv.Itoa(i)))
+			}(i)
+		}
+		wg.Wait()
+
+		revisions, err := r.GetRevisions(ctx, "concurrent_update")
+		require.NoError(t, err)
+		require.Len(t, revisions, 10)
+		// every revision starts from url set by previous one
+		previous := "http://example.com/0"
+		for i, revision := range revisions {
+			assert.Equal(t, i+1, revision.Revision)
+			assert.Equal(t, previous, revision.OldURL)
+			previous = revision.NewURL
+		}
+		url, err := r.GetURL(ctx, "concurrent_update")
+		require.NoError(t, err)
+		assert.Equal(t, previous, url.URL)
+	})
+
+	t.Run("update_metadata", func(t *testing.T) {
+		testUpdateMetadata(t, r)
+	})
+
+	t.Run("get_urls_page", func(t *testing.T) {
+		testGetURLSPage(t, r)
+	})
+
+	t.Run("scan_user_urls", func(t *testing.T) {
+		testScanUserURLS(t, r)
+	})
+
+	t.Run("create_urls_conflict", func(t *testing.T) {
+		testCreateURLSConflict(t, r)
+	})
+}
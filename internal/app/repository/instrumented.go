@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/metrics"
+	"github.com/rutkin/url-shortener/internal/app/models"
+)
+
+// create new instance of repository that reports operation latency and errors to metrics
+func NewInstrumentedRepository(r Repository, backend string) *instrumentedRepository {
+	return &instrumentedRepository{r, backend}
+}
+
+type instrumentedRepository struct {
+	Repository
+	backend string
+}
+
+// not found, deleted, conflict and ownership results are part of normal flow and are not counted as errors
+func (r *instrumentedRepository) observe(operation string, start time.Time, err error) {
+	if isCacheableError(err) || errors.Is(err, ErrConflict) || errors.Is(err, ErrURLExhausted) || errors.Is(err, ErrNotOwner) {
+		err = nil
+	}
+	metrics.ObserveStorage(r.backend, operation, time.Since(start), err)
+}
+
+// store urls
+func (r *instrumentedRepository) CreateURLS(ctx context.Context, urls []URLRecord) error {
+	start := time.Now()
+	err := r.Repository.CreateURLS(ctx, urls)
+	r.observe("create_urls", start, err)
+	return err
+}
+
+// store url
+func (r *instrumentedRepository) CreateURL(ctx context.Context, urlRecord URLRecord) error {
+	start := time.Now()
+	err := r.Repository.CreateURL(ctx, urlRecord)
+	r.observe("create_url", start, err)
+	return err
+}
+
+// get url
+func (r *instrumentedRepository) GetURL(ctx context.Context, id string) (URLRecord, error) {
+	start := time.Now()
+	url, err := r.Repository.GetURL(ctx, id)
+	r.observe("get_url", start, err)
+	return url, err
+}
+
+// get urls
+func (r *instrumentedRepository) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error) {
+	start := time.Now()
+	page, err := r.Repository.GetURLS(ctx, userID, filter)
+	r.observe("get_urls", start, err)
+	return page, err
+}
+
+// delete urls
+func (r *instrumentedRepository) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	start := time.Now()
+	err := r.Repository.DeleteURLS(ctx, urls, userID)
+	r.observe("delete_urls", start, err)
+	return err
+}
+
+// add redirects
+func (r *instrumentedRepository) AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error {
+	start := time.Now()
+	err := r.Repository.AddRedirects(ctx, redirects)
+	r.observe("add_redirects", start, err)
+	return err
+}
+
+// get stats
+func (r *instrumentedRepository) GetStats(ctx context.Context) (models.StatRecord, error) {
+	start := time.Now()
+	stats, err := r.Repository.GetStats(ctx)
+	r.observe("get_stats", start, err)
+	return stats, err
+}
+
+// get domains
+func (r *instrumentedRepository) GetDomains(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	domains, err := r.Repository.GetDomains(ctx)
+	r.observe("get_domains", start, err)
+	return domains, err
+}
+
+// delete urls of domains
+func (r *instrumentedRepository) DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error) {
+	start := time.Now()
+	deleted, err := r.Repository.DeleteURLSByDomains(ctx, domains)
+	r.observe("delete_urls_by_domains", start, err)
+	return deleted, err
+}
+
+// scan user urls
+func (r *instrumentedRepository) ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error {
+	start := time.Now()
+	err := r.Repository.ScanUserURLS(ctx, userID, filter, fn)
+	r.observe("scan_user_urls", start, err)
+	return err
+}
+
+// scan urls
+func (r *instrumentedRepository) ScanURLS(ctx context.Context, fn func(URLRecord) error) error {
+	start := time.Now()
+	err := r.Repository.ScanURLS(ctx, fn)
+	r.observe("scan_urls", start, err)
+	return err
+}
+
+// set threats
+func (r *instrumentedRepository) SetThreats(ctx context.Context, threats map[string]Threat) error {
+	start := time.Now()
+	err := r.Repository.SetThreats(ctx, threats)
+	r.observe("set_threats", start, err)
+	return err
+}
+
+// use click
+func (r *instrumentedRepository) ClaimClick(ctx context.Context, id string) error {
+	start := time.Now()
+	err := r.Repository.ClaimClick(ctx, id)
+	r.observe("claim_click", start, err)
+	return err
+}
+
+// change url
+func (r *instrumentedRepository) UpdateURL(ctx context.Context, id string, userID string, url string) error {
+	start := time.Now()
+	err := r.Repository.UpdateURL(ctx, id, userID, url)
+	r.observe("update_url", start, err)
+	return err
+}
+
+// change metadata
+func (r *instrumentedRepository) UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error) {
+	start := time.Now()
+	record, err := r.Repository.UpdateMetadata(ctx, id, userID, update)
+	r.observe("update_metadata", start, err)
+	return record, err
+}
+
+// get revisions
+func (r *instrumentedRepository) GetRevisions(ctx context.Context, id string) ([]models.URLRevision, error) {
+	start := time.Now()
+	revisions, err := r.Repository.GetRevisions(ctx, id)
+	r.observe("get_revisions", start, err)
+	return revisions, err
+}
+
+// get time series
+func (r *instrumentedRepository) GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error) {
+	start := time.Now()
+	points, err := r.Repository.GetTimeSeries(ctx, from, to)
+	r.observe("get_time_series", start, err)
+	return points, err
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// schema migration, backfill fills new columns of existing rows in the same transaction
+type migration struct {
+	statements []string
+	backfill   func(ctx context.Context, tx *sql.Tx, dialect sqlDialect) error
+}
+
+// short urls changed by one backfill update
+const backfillBatchSize = 500
+
+// schema migrations shared by all sql dialects, applied in order, version is index + 1
+var migrations = []migration{
+	{
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS shortener (shortURL VARCHAR (50) UNIQUE NOT NULL, LongURL VARCHAR (1000) NOT NULL, userID VARCHAR (50) NOT NULL, deleted BOOLEAN NOT NULL)",
+			"CREATE INDEX IF NOT EXISTS long_url_idx ON shortener (LongURL)",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN created_at TIMESTAMP",
+			"ALTER TABLE shortener ADD COLUMN domain VARCHAR (255) NOT NULL DEFAULT ''",
+			"ALTER TABLE shortener ADD COLUMN redirects BIGINT NOT NULL DEFAULT 0",
+			"CREATE INDEX IF NOT EXISTS domain_idx ON shortener (domain)",
+		},
+		backfill: backfillDomains,
+	},
+	{
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS redirects_daily (shortURL VARCHAR (50) NOT NULL, day VARCHAR (10) NOT NULL, count BIGINT NOT NULL, PRIMARY KEY (shortURL, day))",
+			"CREATE INDEX IF NOT EXISTS created_at_idx ON shortener (created_at)",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN threat VARCHAR (50) NOT NULL DEFAULT ''",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN title VARCHAR (255) NOT NULL DEFAULT ''",
+			"ALTER TABLE shortener ADD COLUMN preview BOOLEAN NOT NULL DEFAULT FALSE",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN redirect_type INTEGER NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN password_hash VARCHAR (100) NOT NULL DEFAULT ''",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE shortener ADD COLUMN clicks INTEGER NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS url_revisions (shortURL VARCHAR (50) NOT NULL, revision INTEGER NOT NULL, old_url VARCHAR (1000) NOT NULL, new_url VARCHAR (1000) NOT NULL, userID VARCHAR (50) NOT NULL, changed_at TIMESTAMP NOT NULL, PRIMARY KEY (shortURL, revision))",
+		},
+	},
+	{
+		statements: []string{
+			"ALTER TABLE shortener ADD COLUMN tags VARCHAR (1000) NOT NULL DEFAULT ''",
+			"ALTER TABLE shortener ADD COLUMN notes TEXT NOT NULL DEFAULT ''",
+		},
+	},
+	{
+		statements: []string{
+			"CREATE INDEX IF NOT EXISTS user_created_at_idx ON shortener (userID, created_at, shortURL)",
+		},
+		backfill: backfillCreatedAt,
+	},
+}
+
+// domain is parsed from long url, it can't be done in sql of every dialect,
+// so urls are grouped by domain and every group is updated in batches
+func backfillDomains(ctx context.Context, tx *sql.Tx, dialect sqlDialect) error {
+	rows, err := tx.QueryContext(ctx, "SELECT shortURL, LongURL FROM shortener")
+	if err != nil {
+		return err
+	}
+	domains := make(map[string][]string) // [domain, short urls]
+	for rows.Next() {
+		var id, longURL string
+		if err := rows.Scan(&id, &longURL); err != nil {
+			rows.Close()
+			return err
+		}
+		if domain := domainOf(longURL); domain != "" {
+			domains[domain] = append(domains[domain], id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	for domain, ids := range domains {
+		for len(ids) > 0 {
+			batch := ids[:min(len(ids), backfillBatchSize)]
+			ids = ids[len(batch):]
+			condition, args := dialect.anyOf("shortURL", 2, batch)
+			_, err := tx.ExecContext(ctx, "UPDATE shortener SET domain = $1 WHERE "+condition, append([]interface{}{domain}, args...)...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// urls created before creation time was stored are listed as the oldest ones,
+// keyset pagination can't compare null creation time
+func backfillCreatedAt(ctx context.Context, tx *sql.Tx, _ sqlDialect) error {
+	_, err := tx.ExecContext(ctx, "UPDATE shortener SET created_at = $1 WHERE created_at IS NULL", time.Time{})
+	return err
+}
+
+// apply new migrations in one transaction
+func migrate(ctx context.Context, db *sql.DB, dialect sqlDialect) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create transaction", zap.String("error", err.Error()))
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)")
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to create migrations table", zap.String("error", err.Error()))
+		return err
+	}
+
+	if dialect.lockMigrations != "" {
+		_, err = tx.ExecContext(ctx, dialect.lockMigrations)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to lock migrations table", zap.String("error", err.Error()))
+			return err
+		}
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		logger.FromContext(ctx).Error("Failed to get schema version", zap.String("error", err.Error()))
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		for _, statement := range migrations[version].statements {
+			_, err = tx.ExecContext(ctx, statement)
+			if err != nil {
+				logger.FromContext(ctx).Error("Failed to apply migration",
+					zap.Int("version", version+1),
+					zap.String("error", err.Error()))
+				return err
+			}
+		}
+		if migrations[version].backfill != nil {
+			err = migrations[version].backfill(ctx, tx, dialect)
+			if err != nil {
+				logger.FromContext(ctx).Error("Failed to backfill migration",
+					zap.Int("version", version+1),
+					zap.String("error", err.Error()))
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version+1)
+		if err != nil {
+			logger.FromContext(ctx).Error("Failed to save schema version", zap.String("error", err.Error()))
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+)
+
+// ErrInvalidCursor - page cursor is malformed
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// urls read by one step of user urls scan
+const scanChunkSize = 100
+
+// url of user with its deletion flag
+type listedURL struct {
+	URLRecord
+	deleted bool
+}
+
+// record listed in user urls
+func userURLRecord(url listedURL) models.URLRecord {
+	return models.URLRecord{ShortURL: url.ID, OriginalURL: url.URL, Title: url.Title, Tags: url.Tags, Notes: url.Notes, Deleted: url.deleted}
+}
+
+// check url matches filter of user urls, cursor and limit are not checked
+func matchesFilter(url listedURL, filter models.URLFilter) bool {
+	if url.deleted && !filter.IncludeDeleted {
+		return false
+	}
+	if filter.Search != "" && !strings.Contains(strings.ToLower(url.URL), strings.ToLower(filter.Search)) {
+		return false
+	}
+	if filter.Tag == "" {
+		return true
+	}
+	for _, tag := range url.Tags {
+		if tag == filter.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// check url a is created before url b, short url breaks ties of equal creation time
+func createdBefore(a, b URLRecord) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// check url a is listed before url b in creation order of filter
+func listedBefore(a, b URLRecord, order string) bool {
+	if order == models.OrderAsc {
+		return createdBefore(a, b)
+	}
+	return createdBefore(b, a)
+}
+
+// opaque position of url in creation order
+func encodeCursor(record URLRecord) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(record.CreatedAt.UTC().Format(time.RFC3339Nano) + " " + record.ID))
+}
+
+// creation time and short url of cursor position
+func decodeCursor(cursor string) (URLRecord, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return URLRecord{}, ErrInvalidCursor
+	}
+	created, id, ok := strings.Cut(string(value), " ")
+	if !ok {
+		return URLRecord{}, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return URLRecord{}, ErrInvalidCursor
+	}
+	return URLRecord{ID: id, CreatedAt: createdAt}, nil
+}
+
+// call fn for user urls of backends without creation order index: collect passes every user url
+// to add, only creation time and short url of matching ones are kept and sorted, then urls are
+// loaded by chunks in that order, load skips missing urls
+func scanUserURLS(ctx context.Context, filter models.URLFilter, collect func(add func(listedURL)) error,
+	load func(ids []string) ([]listedURL, error), fn func(models.URLRecord) error) error {
+	var positions []URLRecord
+	err := collect(func(url listedURL) {
+		if matchesFilter(url, filter) {
+			positions = append(positions, URLRecord{ID: url.ID, CreatedAt: url.CreatedAt})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(positions, func(i, j int) bool { return listedBefore(positions[i], positions[j], filter.Order) })
+
+	ids := make([]string, 0, scanChunkSize)
+	for len(positions) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk := positions[:min(len(positions), scanChunkSize)]
+		positions = positions[len(chunk):]
+		ids = ids[:0]
+		for _, position := range chunk {
+			ids = append(ids, position.ID)
+		}
+		urls, err := load(ids)
+		if err != nil {
+			return err
+		}
+		for _, url := range urls {
+			// url could be changed after positions were collected
+			if !matchesFilter(url, filter) {
+				continue
+			}
+			if err := fn(userURLRecord(url)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sort filtered urls in creation order of filter and cut page after cursor
+func paginate(urls []listedURL, filter models.URLFilter) (models.URLPage, error) {
+	sort.Slice(urls, func(i, j int) bool { return listedBefore(urls[i].URLRecord, urls[j].URLRecord, filter.Order) })
+
+	start := 0
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return models.URLPage{}, err
+		}
+		start = sort.Search(len(urls), func(i int) bool { return listedBefore(cursor, urls[i].URLRecord, filter.Order) })
+	}
+
+	var page models.URLPage
+	for i := start; i < len(urls) && (filter.Limit <= 0 || len(page.URLS) < filter.Limit); i++ {
+		page.URLS = append(page.URLS, userURLRecord(urls[i]))
+		if len(page.URLS) == filter.Limit && i+1 < len(urls) {
+			page.NextCursor = encodeCursor(urls[i].URLRecord)
+		}
+	}
+	return page, nil
+}
@@ -1,8 +1,12 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/rutkin/url-shortener/internal/app/config"
 	"github.com/rutkin/url-shortener/internal/app/models"
@@ -14,6 +18,15 @@ var ErrConflict = errors.New("repository conflict")
 // error url deleted
 var ErrURLDeleted = errors.New("url deleted")
 
+// error all clicks of url are used
+var ErrURLExhausted = errors.New("url clicks exhausted")
+
+// error url is changed by user who doesn't own it
+var ErrNotOwner = errors.New("url belongs to other user")
+
+var errUnknownStorageBackend = errors.New("unknown storage backend")
+var errDatabaseNotConfigured = errors.New("database dsn is not configured")
+
 // URLRecord - record to store info about URL in repository
 type URLRecord struct {
 	// ID - short url id
@@ -22,29 +35,188 @@ type URLRecord struct {
 	URL string
 	// UserID - user id
 	UserID string
+	// CreatedAt - creation time, current time is used when it is not set
+	CreatedAt time.Time
+	// Threat - threat type reported by reputation provider, empty for safe url
+	Threat string
+	// Title - owner-provided title
+	Title string
+	// Preview - preview page is shown instead of every redirect
+	Preview bool
+	// RedirectType - redirect status code, 0 is default redirect
+	RedirectType int
+	// PasswordHash - bcrypt hash of password required for redirect, empty for public url
+	PasswordHash string
+	// MaxClicks - number of redirects allowed, 0 is unlimited
+	MaxClicks int
+	// Clicks - number of used clicks of url with max clicks
+	Clicks int
+	// Tags - owner-provided labels
+	Tags []string
+	// Notes - owner-provided free-form notes
+	Notes string
+}
+
+// URLMetadata - owner-provided descriptions of url
+type URLMetadata struct {
+	Title string
+	Tags  []string
+	Notes string
+}
+
+// MetadataUpdate - changed descriptions of url, nil fields are kept
+type MetadataUpdate struct {
+	Title *string
+	Tags  *[]string
+	Notes *string
+}
+
+// tags stored comma separated
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// escape wildcards of LIKE pattern with backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Threat - verdict of reputation check of short url destination
+type Threat struct {
+	// URL - checked destination, verdict is set only while short url points to it
+	URL string
+	// Type - threat type, empty type marks url safe
+	Type string
+}
+
+// RedirectKey - redirects of short url on day
+type RedirectKey struct {
+	// ID - short url id
+	ID string
+	// Day - UTC day in models.DayLayout format
+	Day string
 }
 
 // Repository - interface for store records
 type Repository interface {
-	// Create
-	CreateURLS(urls []URLRecord) error
-	CreateURL(urlRecord URLRecord) error
-	GetURL(id string) (string, error)
-	GetURLS(userID string) ([]models.URLRecord, error)
-	DeleteURLS(urls []string, userID string) error
-	GetStats() (models.StatRecord, error)
+	// create all urls or none of them, ErrConflict when one of short urls exists
+	CreateURLS(ctx context.Context, urls []URLRecord) error
+	CreateURL(ctx context.Context, urlRecord URLRecord) error
+	GetURL(ctx context.Context, id string) (URLRecord, error)
+	// page of user urls matching filter
+	GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error)
+	// call fn for all user urls matching filter in its order, cursor and limit are ignored,
+	// iteration stops on fn error
+	ScanUserURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error
+	DeleteURLS(ctx context.Context, urls []string, userID string) error
+	GetStats(ctx context.Context) (models.StatRecord, error)
+	// add redirect counts by short url id and day
+	AddRedirects(ctx context.Context, redirects map[RedirectKey]int64) error
+	// daily counts of created urls and redirects in [from, to) range
+	GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error)
+	// distinct domains of not deleted urls
+	GetDomains(ctx context.Context) ([]string, error)
+	// mark urls of domains as deleted, returns ids of deleted urls
+	DeleteURLSByDomains(ctx context.Context, domains []string) ([]string, error)
+	// call fn for every not deleted url, iteration stops on fn error
+	ScanURLS(ctx context.Context, fn func(URLRecord) error) error
+	// set threats by short url id, urls changed since their check are skipped
+	SetThreats(ctx context.Context, threats map[string]Threat) error
+	// atomically use one click of url with max clicks, ErrURLExhausted when all clicks are used
+	ClaimClick(ctx context.Context, id string) error
+	// change url of user owned short url and record revision, threat of previous url is cleared
+	UpdateURL(ctx context.Context, id string, userID string, url string) error
+	// revisions of short url in order
+	GetRevisions(ctx context.Context, id string) ([]models.URLRevision, error)
+	// change given title, tags and notes of user owned short url in one step, returns changed url
+	UpdateMetadata(ctx context.Context, id string, userID string, update MetadataUpdate) (URLRecord, error)
+	Ping(ctx context.Context) error
 	Close() error
 }
 
-// create new instance of repository in config settings
+// backends shared by several shortener instances, cache of one instance isn't invalidated by writes of others
+var sharedBackends = map[string]bool{"redis": true, "postgres": true}
+
+// max ttl of cache entries of shared backend, it bounds staleness after writes of other instances
+const sharedCacheTTL = 5 * time.Second
+
+// create new instance of repository in config settings, storage is instrumented with metrics
+// and wrapped with redirect cache when it is enabled
 func NewRepository(db *sql.DB) (Repository, error) {
-	if db != nil {
-		return NewInDatabaseRepository(db)
+	storage, backend, err := newStorageRepository(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Repository = NewInstrumentedRepository(storage, backend)
+	if config.ServerConfig.CacheSize > 0 {
+		ttl := time.Duration(config.ServerConfig.CacheTTL)
+		negativeTTL := time.Duration(config.ServerConfig.CacheNegativeTTL)
+		if sharedBackends[backend] {
+			ttl, negativeTTL = min(ttl, sharedCacheTTL), min(negativeTTL, sharedCacheTTL)
+		}
+		r = NewCachedRepository(r, config.ServerConfig.CacheSize, ttl, negativeTTL)
+	}
+
+	return r, nil
+}
+
+// backend name from config, or chosen by other settings when backend is not set
+func selectBackend(db *sql.DB) string {
+	backend := config.ServerConfig.StorageBackend
+	if backend == "" {
+		switch {
+		case db != nil:
+			backend = "database"
+		case config.ServerConfig.RedisAddress != "":
+			backend = "redis"
+		case config.ServerConfig.FileStoragePath != "":
+			backend = "file"
+		default:
+			backend = "memory"
+		}
 	}
+	return backend
+}
 
-	if config.ServerConfig.FileStoragePath == "" {
-		return NewInMemoryRepository(), nil
+// name of storage backend used by repository, database backend is named by sql dialect
+func BackendName(db *sql.DB) string {
+	backend := selectBackend(db)
+	if backend == "database" {
+		return dialectForDSN(config.ServerConfig.DatabaseDSN).name
 	}
+	return backend
+}
+
+// create storage by backend name from config, or by other settings when backend is not set
+func newStorageRepository(db *sql.DB) (Repository, string, error) {
+	backend := selectBackend(db)
 
-	return NewInFileRepository(config.ServerConfig.FileStoragePath)
+	var r Repository
+	var err error
+	switch backend {
+	case "memory":
+		r = NewInMemoryRepository()
+	case "file":
+		r, err = NewInFileRepository(config.ServerConfig.FileStoragePath)
+	case "database":
+		if db == nil {
+			return nil, "", errDatabaseNotConfigured
+		}
+		r, err = NewInDatabaseRepository(db, dialectForDSN(config.ServerConfig.DatabaseDSN))
+		backend = dialectForDSN(config.ServerConfig.DatabaseDSN).name
+	case "redis":
+		r, err = NewInRESPRepository(config.ServerConfig.RedisAddress)
+	case "bolt":
+		r, err = NewInBoltRepository(config.ServerConfig.BoltStoragePath)
+	default:
+		return nil, "", fmt.Errorf("%w '%s'", errUnknownStorageBackend, backend)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return r, backend, nil
 }
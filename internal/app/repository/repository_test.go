@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// check url with two clicks is exhausted after them
+func testClaimClick(t *testing.T, r Repository) {
+	ctx := context.Background()
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "limited", URL: "http://example.com/limited", UserID: "user1", MaxClicks: 2}))
+
+	require.NoError(t, r.ClaimClick(ctx, "limited"))
+	require.NoError(t, r.ClaimClick(ctx, "limited"))
+	assert.ErrorIs(t, r.ClaimClick(ctx, "limited"), ErrURLExhausted)
+
+	url, err := r.GetURL(ctx, "limited")
+	require.NoError(t, err)
+	assert.Equal(t, 2, url.MaxClicks)
+	assert.Equal(t, 2, url.Clicks)
+
+	// click of unknown url doesn't create it
+	assert.Error(t, r.ClaimClick(ctx, "unknown"))
+	_, err = r.GetURL(ctx, "unknown")
+	assert.Error(t, err)
+}
+
+func testUpdateURL(t *testing.T, r Repository) {
+	ctx := context.Background()
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "retargeted", URL: "http://example.com/old", UserID: "user1"}))
+	require.NoError(t, r.SetThreats(ctx, map[string]Threat{"retargeted": {URL: "http://example.com/old", Type: "MALWARE"}}))
+
+	assert.ErrorIs(t, r.UpdateURL(ctx, "retargeted", "user2", "http://example.com/stolen"), ErrNotOwner)
+	require.NoError(t, r.UpdateURL(ctx, "retargeted", "user1", "http://example.com/new"))
+	require.NoError(t, r.UpdateURL(ctx, "retargeted", "user1", "http://example.com/newer"))
+
+	url, err := r.GetURL(ctx, "retargeted")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/newer", url.URL)
+	assert.Empty(t, url.Threat)
+
+	// verdict of previous url is late and skipped, verdict of current url is set
+	require.NoError(t, r.SetThreats(ctx, map[string]Threat{"retargeted": {URL: "http://example.com/new", Type: "MALWARE"}}))
+	url, err = r.GetURL(ctx, "retargeted")
+	require.NoError(t, err)
+	assert.Empty(t, url.Threat)
+	require.NoError(t, r.SetThreats(ctx, map[string]Threat{"retargeted": {URL: "http://example.com/newer", Type: "PHISHING"}}))
+	url, err = r.GetURL(ctx, "retargeted")
+	require.NoError(t, err)
+	assert.Equal(t, "PHISHING", url.Threat)
+
+	revisions, err := r.GetRevisions(ctx, "retargeted")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 1, revisions[0].Revision)
+	assert.Equal(t, "http://example.com/old", revisions[0].OldURL)
+	assert.Equal(t, "http://example.com/new", revisions[0].NewURL)
+	assert.Equal(t, "user1", revisions[0].UserID)
+	assert.Equal(t, 2, revisions[1].Revision)
+	assert.Equal(t, "http://example.com/new", revisions[1].OldURL)
+	assert.False(t, revisions[1].CreatedAt.IsZero())
+}
+
+func testUpdateMetadata(t *testing.T, r Repository) {
+	ctx := context.Background()
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "described", URL: "http://example.com/described", UserID: "user3",
+		Tags: []string{"go"}, Notes: "first"}))
+
+	page, err := r.GetURLS(ctx, "user3", models.URLFilter{Tag: "go"})
+	require.NoError(t, err)
+	assert.Equal(t, []models.URLRecord{
+		{ShortURL: "described", OriginalURL: "http://example.com/described", Tags: []string{"go"}, Notes: "first"},
+	}, page.URLS)
+
+	title, tags, notes := "Docs", []string{"docs", "go-lang"}, "second"
+	update := MetadataUpdate{Title: &title, Tags: &tags, Notes: &notes}
+	_, err = r.UpdateMetadata(ctx, "described", "user1", update)
+	assert.ErrorIs(t, err, ErrNotOwner)
+	url, err := r.UpdateMetadata(ctx, "described", "user3", update)
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/described", url.URL)
+	assert.Equal(t, "Docs", url.Title)
+
+	url, err = r.GetURL(ctx, "described")
+	require.NoError(t, err)
+	assert.Equal(t, "Docs", url.Title)
+	assert.Equal(t, []string{"docs", "go-lang"}, url.Tags)
+	assert.Equal(t, "second", url.Notes)
+
+	// fields missing in update are kept
+	notes = "third"
+	url, err = r.UpdateMetadata(ctx, "described", "user3", MetadataUpdate{Notes: &notes})
+	require.NoError(t, err)
+	assert.Equal(t, "Docs", url.Title)
+	assert.Equal(t, []string{"docs", "go-lang"}, url.Tags)
+	assert.Equal(t, "third", url.Notes)
+
+	for tag, expected := range map[string]int{"go": 0, "go_lang": 0, "go-lang": 1, "": 1} {
+		page, err := r.GetURLS(ctx, "user3", models.URLFilter{Tag: tag})
+		require.NoError(t, err)
+		assert.Len(t, page.URLS, expected, tag)
+	}
+}
+
+// check pages of five urls created a minute apart, third one is deleted
+func testGetURLSPage(t *testing.T, r Repository) {
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123000, time.UTC)
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, r.CreateURL(ctx, URLRecord{ID: fmt.Sprintf("page%d", i), URL: fmt.Sprintf("http://example.com/Page/%d", i),
+			UserID: "user4", CreatedAt: created.Add(time.Duration(i) * time.Minute)}))
+	}
+	require.NoError(t, r.DeleteURLS(ctx, []string{"page3"}, "user4"))
+
+	ids := func(page models.URLPage) []string {
+		var result []string
+		for _, url := range page.URLS {
+			result = append(result, url.ShortURL)
+		}
+		return result
+	}
+
+	page, err := r.GetURLS(ctx, "user4", models.URLFilter{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"page5", "page4"}, ids(page))
+	require.NotEmpty(t, page.NextCursor)
+	page, err = r.GetURLS(ctx, "user4", models.URLFilter{Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"page2", "page1"}, ids(page))
+	assert.Empty(t, page.NextCursor)
+
+	page, err = r.GetURLS(ctx, "user4", models.URLFilter{Order: models.OrderAsc, IncludeDeleted: true, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"page1", "page2", "page3"}, ids(page))
+	assert.True(t, page.URLS[2].Deleted)
+	page, err = r.GetURLS(ctx, "user4", models.URLFilter{Order: models.OrderAsc, IncludeDeleted: true, Limit: 3, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"page4", "page5"}, ids(page))
+
+	page, err = r.GetURLS(ctx, "user4", models.URLFilter{Search: "page/4"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"page4"}, ids(page))
+	page, err = r.GetURLS(ctx, "user4", models.URLFilter{Search: "%"})
+	require.NoError(t, err)
+	assert.Empty(t, page.URLS)
+
+	_, err = r.GetURLS(ctx, "user4", models.URLFilter{Cursor: "broken"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	// scan ignores cursor and limit, iteration stops on fn error
+	var scanned []string
+	errStop := errors.New("stop")
+	err = r.ScanUserURLS(ctx, "user4", models.URLFilter{Order: models.OrderDesc, Limit: 1, Cursor: "broken"}, func(url models.URLRecord) error {
+		scanned = append(scanned, url.ShortURL)
+		if len(scanned) == 3 {
+			return errStop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, []string{"page5", "page4", "page2"}, scanned)
+}
+
+// check scan of more urls than one chunk keeps creation order and filter
+func testScanUserURLS(t *testing.T, r Repository) {
+	ctx := context.Background()
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var urls []URLRecord
+	var expected []string
+	for i := 0; i < 2*scanChunkSize+5; i++ {
+		url := URLRecord{ID: fmt.Sprintf("scan%03d", i), URL: fmt.Sprintf("http://example.com/scan/%d", i),
+			UserID: "user9", CreatedAt: created.Add(time.Duration(i) * time.Second)}
+		if i%3 == 0 {
+			url.Tags = []string{"third"}
+			expected = append(expected, url.ID)
+		}
+		urls = append(urls, url)
+	}
+	require.NoError(t, r.CreateURLS(ctx, urls))
+	require.NoError(t, r.DeleteURLS(ctx, []string{"scan000"}, "user9"))
+	expected = expected[1:]
+
+	scan := func(filter models.URLFilter) []string {
+		var scanned []string
+		require.NoError(t, r.ScanUserURLS(ctx, "user9", filter, func(url models.URLRecord) error {
+			scanned = append(scanned, url.ShortURL)
+			return nil
+		}))
+		return scanned
+	}
+	assert.Equal(t, expected, scan(models.URLFilter{Order: models.OrderAsc, Tag: "third"}))
+	all := scan(models.URLFilter{Order: models.OrderDesc})
+	require.Len(t, all, 2*scanChunkSize+4)
+	assert.Equal(t, fmt.Sprintf("scan%03d", 2*scanChunkSize+4), all[0])
+	assert.Equal(t, "scan001", all[len(all)-1])
+}
+
+func TestInMemoryRepositoryScanUserURLS(t *testing.T) {
+	testScanUserURLS(t, NewInMemoryRepository())
+}
+
+func TestInMemoryRepositoryClaimClickConcurrent(t *testing.T) {
+	testClaimClickConcurrent(t, NewInMemoryRepository())
+}
+
+// check concurrent claims don't use more than max clicks
+func testClaimClickConcurrent(t *testing.T, r Repository) {
+	ctx := context.Background()
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "concurrent", URL: "http://example.com", UserID: "user", MaxClicks: 10}))
+
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r.ClaimClick(ctx, "concurrent") == nil {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(10), claimed.Load())
+
+	url, err := r.GetURL(ctx, "concurrent")
+	require.NoError(t, err)
+	assert.Equal(t, 10, url.Clicks)
+}
+
+// check url or batch with existing or repeated short url is not created
+func testCreateURLSConflict(t *testing.T, r Repository) {
+	ctx := context.Background()
+	require.NoError(t, r.CreateURL(ctx, URLRecord{ID: "taken", URL: "http://example.com/taken", UserID: "user5"}))
+	assert.ErrorIs(t, r.CreateURL(ctx, URLRecord{ID: "taken", URL: "http://example.com/other", UserID: "user6"}), ErrConflict)
+
+	for _, batch := range [][]URLRecord{
+		{{ID: "batch1", URL: "http://example.com/batch1", UserID: "user6"}, {ID: "taken", URL: "http://example.com/batch2", UserID: "user6"}},
+		{{ID: "batch1", URL: "http://example.com/batch1", UserID: "user6"}, {ID: "batch1", URL: "http://example.com/batch2", UserID: "user6"}},
+	} {
+		assert.ErrorIs(t, r.CreateURLS(ctx, batch), ErrConflict)
+		_, err := r.GetURL(ctx, "batch1")
+		assert.Error(t, err)
+	}
+	url, err := r.GetURL(ctx, "taken")
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/taken", url.URL)
+	assert.Equal(t, "user5", url.UserID)
+
+	require.NoError(t, r.CreateURLS(ctx, []URLRecord{{ID: "batch1", URL: "http://example.com/batch1", UserID: "user6"}}))
+}
+
+func TestInMemoryRepositoryCreateURLSConflict(t *testing.T) {
+	testCreateURLSConflict(t, NewInMemoryRepository())
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+)
+
+// number of domains in stats
+const topDomainsLimit = 10
+
+// creation time of record in UTC, current time when it is not set
+func createdAt(urlRecord URLRecord) time.Time {
+	if urlRecord.CreatedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return urlRecord.CreatedAt.UTC()
+}
+
+// lower case host of url, empty when url can't be parsed
+func domainOf(longURL string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// stats of backends without query language, records are added one by one
+type statsCollector struct {
+	dayAgo  time.Time
+	weekAgo time.Time
+	stats   models.StatRecord
+	users   map[string]struct{}
+	domains map[string]int
+}
+
+func newStatsCollector(now time.Time) *statsCollector {
+	return &statsCollector{
+		dayAgo:  now.Add(-24 * time.Hour),
+		weekAgo: now.Add(-7 * 24 * time.Hour),
+		users:   make(map[string]struct{}),
+		domains: make(map[string]int),
+	}
+}
+
+func (c *statsCollector) add(longURL, userID string, created time.Time, deleted bool, redirects int64) {
+	c.stats.URLS++
+	c.users[userID] = struct{}{}
+	if deleted {
+		c.stats.DeletedURLS++
+	} else {
+		c.stats.ActiveURLS++
+		if domain := domainOf(longURL); domain != "" {
+			c.domains[domain]++
+		}
+	}
+	if !created.Before(c.dayAgo) {
+		c.stats.CreatedLastDay++
+	}
+	if !created.Before(c.weekAgo) {
+		c.stats.CreatedLastWeek++
+	}
+	c.stats.Redirects += redirects
+}
+
+func (c *statsCollector) result() models.StatRecord {
+	c.stats.Users = len(c.users)
+	c.stats.TopDomains = make([]models.DomainStat, 0, len(c.domains))
+	for domain, urls := range c.domains {
+		c.stats.TopDomains = append(c.stats.TopDomains, models.DomainStat{Domain: domain, URLS: urls})
+	}
+	sort.Slice(c.stats.TopDomains, func(i, j int) bool {
+		a, b := c.stats.TopDomains[i], c.stats.TopDomains[j]
+		if a.URLS != b.URLS {
+			return a.URLS > b.URLS
+		}
+		return a.Domain < b.Domain
+	})
+	if len(c.stats.TopDomains) > topDomainsLimit {
+		c.stats.TopDomains = c.stats.TopDomains[:topDomainsLimit]
+	}
+	return c.stats
+}
+
+type timeSeriesKey struct {
+	day    string
+	userID string
+	domain string
+}
+
+// daily counts of backends without query language, days out of [from, to) range are skipped
+type timeSeriesCollector struct {
+	from   string
+	to     string
+	points map[timeSeriesKey]*models.TimeSeriesPoint
+}
+
+func newTimeSeriesCollector(from, to time.Time) *timeSeriesCollector {
+	return &timeSeriesCollector{
+		from:   from.UTC().Format(models.DayLayout),
+		to:     to.UTC().Format(models.DayLayout),
+		points: make(map[timeSeriesKey]*models.TimeSeriesPoint),
+	}
+}
+
+func (c *timeSeriesCollector) point(day, userID, domain string) *models.TimeSeriesPoint {
+	if day < c.from || day >= c.to {
+		return nil
+	}
+	key := timeSeriesKey{day, userID, domain}
+	p, ok := c.points[key]
+	if !ok {
+		p = &models.TimeSeriesPoint{Day: day, UserID: userID, Domain: domain}
+		c.points[key] = p
+	}
+	return p
+}
+
+func (c *timeSeriesCollector) addCreated(day, userID, domain string, created int) {
+	if p := c.point(day, userID, domain); p != nil {
+		p.Created += created
+	}
+}
+
+func (c *timeSeriesCollector) addRedirects(day, userID, domain string, redirects int64) {
+	if p := c.point(day, userID, domain); p != nil {
+		p.Redirects += redirects
+	}
+}
+
+// add url with its daily redirects
+func (c *timeSeriesCollector) add(longURL, userID string, created time.Time, dailyRedirects map[string]int64) {
+	domain := domainOf(longURL)
+	if !created.IsZero() {
+		c.addCreated(created.UTC().Format(models.DayLayout), userID, domain, 1)
+	}
+	for day, redirects := range dailyRedirects {
+		c.addRedirects(day, userID, domain, redirects)
+	}
+}
+
+// points ordered by day, user and domain
+func (c *timeSeriesCollector) result() []models.TimeSeriesPoint {
+	result := make([]models.TimeSeriesPoint, 0, len(c.points))
+	for _, p := range c.points {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		return a.Domain < b.Domain
+	})
+	return result
+}
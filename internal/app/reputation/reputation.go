@@ -0,0 +1,211 @@
+// reputation package contains providers of destination url reputation:
+// remote http service and local list of url hash prefixes
+package reputation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultThreat - threat type of hash prefixes without type
+const DefaultThreat = "UNSAFE"
+
+var errUnexpectedStatus = errors.New("unexpected reputation service status")
+var errInvalidPrefix = errors.New("invalid hash prefix")
+
+// request body of http reputation service
+type checkRequest struct {
+	URLS []string `json:"urls"`
+}
+
+// response body of http reputation service, safe urls are not listed
+type checkResponse struct {
+	Matches []struct {
+		URL    string `json:"url"`
+		Threat string `json:"threat"`
+	} `json:"matches"`
+}
+
+// create new instance of http reputation provider
+func NewHTTPChecker(endpoint string, client *http.Client) *HTTPChecker {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPChecker{endpoint: endpoint, client: client}
+}
+
+// HTTPChecker - provider that posts {"urls": [...]} to endpoint
+// and gets {"matches": [{"url": ..., "threat": ...}]} with unsafe urls
+type HTTPChecker struct {
+	endpoint string
+	client   *http.Client
+}
+
+// Check - threat types of unsafe urls
+func (c *HTTPChecker) Check(ctx context.Context, urls []string) (map[string]string, error) {
+	body, err := json.Marshal(checkRequest{URLS: urls})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w %d", errUnexpectedStatus, resp.StatusCode)
+	}
+
+	var result checkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	threats := make(map[string]string, len(result.Matches))
+	for _, match := range result.Matches {
+		threat := match.Threat
+		if threat == "" {
+			threat = DefaultThreat
+		}
+		threats[match.URL] = threat
+	}
+	return threats, nil
+}
+
+// create new instance of hash prefix provider from file,
+// every line is hex encoded prefix of sha256 url expression hash with optional threat type, # starts comment
+func NewHashPrefixChecker(filename string) (*HashPrefixChecker, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHashPrefixes(f)
+}
+
+// ParseHashPrefixes - read hash prefix list
+func ParseHashPrefixes(r io.Reader) (*HashPrefixChecker, error) {
+	c := &HashPrefixChecker{prefixes: make(map[string]string)}
+	lengths := make(map[int]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		prefix, err := hex.DecodeString(fields[0])
+		if err != nil || len(prefix) < 4 || len(prefix) > sha256.Size {
+			return nil, fmt.Errorf("%w on line %d", errInvalidPrefix, line)
+		}
+		threat := DefaultThreat
+		if len(fields) > 1 {
+			threat = fields[1]
+		}
+		c.prefixes[string(prefix)] = threat
+		if !lengths[len(prefix)] {
+			lengths[len(prefix)] = true
+			c.lengths = append(c.lengths, len(prefix))
+		}
+	}
+	return c, scanner.Err()
+}
+
+// HashPrefixChecker - local provider, url is unsafe when hash of one of its
+// host suffix and path prefix expressions starts with listed prefix
+type HashPrefixChecker struct {
+	prefixes map[string]string // [prefix bytes, threat]
+	lengths  []int
+}
+
+// Check - threat types of unsafe urls
+func (c *HashPrefixChecker) Check(ctx context.Context, urls []string) (map[string]string, error) {
+	threats := make(map[string]string)
+	for _, rawURL := range urls {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, expression := range Expressions(rawURL) {
+			if threat, ok := c.match(sha256.Sum256([]byte(expression))); ok {
+				threats[rawURL] = threat
+				break
+			}
+		}
+	}
+	return threats, nil
+}
+
+func (c *HashPrefixChecker) match(hash [sha256.Size]byte) (string, bool) {
+	for _, length := range c.lengths {
+		if threat, ok := c.prefixes[string(hash[:length])]; ok {
+			return threat, true
+		}
+	}
+	return "", false
+}
+
+// Expressions - host suffix and path prefix combinations of url, like "a.b.c/1/2.html?param=1",
+// "b.c/1/" or "b.c/"; at most 5 hosts and 6 paths are used, ip hosts are not split
+func Expressions(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		start := len(labels) - 5
+		if start < 1 {
+			start = 1
+		}
+		for i := start; i < len(labels)-1; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{}
+	if u.RawQuery != "" {
+		paths = append(paths, path+"?"+u.RawQuery)
+	}
+	paths = append(paths, path)
+	components := strings.Split(strings.Trim(path, "/"), "/")
+	prefix := "/"
+	for i := 0; i < len(components) && len(paths) < 6; i++ {
+		if prefix != path {
+			paths = append(paths, prefix)
+		}
+		prefix += components[i] + "/"
+	}
+
+	expressions := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			expressions = append(expressions, h+p)
+		}
+	}
+	return expressions
+}
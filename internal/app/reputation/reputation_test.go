@@ -0,0 +1,66 @@
+package reputation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPChecker(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req checkRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, []string{"https://go.dev/", "https://phishing.test/login"}, req.URLS)
+		w.Write([]byte(`{"matches":[{"url":"https://phishing.test/login","threat":"SOCIAL_ENGINEERING"}]}`))
+	}))
+	defer stub.Close()
+
+	threats, err := NewHTTPChecker(stub.URL, stub.Client()).Check(context.Background(),
+		[]string{"https://go.dev/", "https://phishing.test/login"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"https://phishing.test/login": "SOCIAL_ENGINEERING"}, threats)
+
+	t.Run("unexpected_status", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+
+		_, err := NewHTTPChecker(failing.URL, nil).Check(context.Background(), []string{"https://go.dev/"})
+		assert.ErrorIs(t, err, errUnexpectedStatus)
+	})
+}
+
+func TestExpressions(t *testing.T) {
+	assert.Equal(t, []string{
+		"a.b.c/1/2.html?param=1", "a.b.c/1/2.html", "a.b.c/", "a.b.c/1/",
+		"b.c/1/2.html?param=1", "b.c/1/2.html", "b.c/", "b.c/1/",
+	}, Expressions("http://a.b.c/1/2.html?param=1"))
+	assert.Equal(t, []string{"1.2.3.4/"}, Expressions("http://1.2.3.4"))
+	assert.Nil(t, Expressions("not a url"))
+}
+
+func TestHashPrefixChecker(t *testing.T) {
+	hash := sha256.Sum256([]byte("phishing.test/"))
+	checker, err := ParseHashPrefixes(strings.NewReader(
+		"# local list\n" + hex.EncodeToString(hash[:4]) + " MALWARE\n" + hex.EncodeToString(hash[:]) + "\n"))
+	require.NoError(t, err)
+
+	threats, err := checker.Check(context.Background(), []string{
+		"https://login.phishing.test/account?id=1",
+		"https://go.dev/",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"https://login.phishing.test/account?id=1": "MALWARE"}, threats)
+
+	_, err = ParseHashPrefixes(strings.NewReader("abc\n"))
+	assert.ErrorIs(t, err, errInvalidPrefix)
+}
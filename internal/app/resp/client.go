@@ -0,0 +1,422 @@
+// resp package provides minimal client for servers speaking Redis serialization protocol
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// error returned when reply has unexpected type
+var ErrUnexpectedReply = errors.New("unexpected reply")
+
+// ErrTxAborted - watched key was changed by other client in every attempt of transaction
+var ErrTxAborted = errors.New("transaction aborted")
+
+// Error - error reply from server
+type Error string
+
+// error message
+func (e Error) Error() string {
+	return string(e)
+}
+
+const maxIdleConns = 16
+const dialTimeout = 5 * time.Second
+
+// attempts of transaction with watched keys
+const maxTxAttempts = 10
+
+// Client - pool of connections to RESP server, safe for concurrent use
+type Client struct {
+	addr     string
+	password string
+	db       int
+	idle     chan *conn
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+}
+
+// create new client, addr is host:port or redis://[:password@]host:port[/db] url
+func Dial(addr string) (*Client, error) {
+	c := &Client{addr: addr, idle: make(chan *conn, maxIdleConns)}
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.addr = u.Host
+		c.password, _ = u.User.Password()
+		if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+			c.db, err = strconv.Atoi(db)
+			if err != nil {
+				return nil, fmt.Errorf("invalid database number '%s'", db)
+			}
+		}
+	}
+
+	if _, err := c.Do(context.Background(), "PING"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) dial() (*conn, error) {
+	netConn, err := net.DialTimeout("tcp", c.addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{netConn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}
+
+	var setup [][]string
+	if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	replies, err := cn.pipeline(setup)
+	if err == nil {
+		err = FirstError(replies)
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+		return c.dial()
+	}
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.netConn.Close()
+	}
+}
+
+// send command and read reply, error replies are returned as Error
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// send batch of commands in one round trip and read replies in the same order,
+// error replies are returned as Error values in result; cancelled context interrupts
+// network io and connection is dropped
+func (c *Client) Pipeline(ctx context.Context, cmds [][]string) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	cn.netConn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		cn.netConn.SetDeadline(time.Now())
+	})
+
+	replies, err := cn.pipeline(cmds)
+	if !stop() && err != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		cn.netConn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return replies, nil
+}
+
+// Tx - connection with watched keys, reads are sent by Do and writes are applied by Exec
+// only when watched keys weren't changed since they were watched
+type Tx struct {
+	cn       *conn
+	executed bool
+	// connection io failed, connection is dropped
+	broken bool
+}
+
+// run fn in transaction watching keys, fn is run again when watched keys are changed before its Exec;
+// cancelled context interrupts network io and connection is dropped
+func (c *Client) Watch(ctx context.Context, keys []string, fn func(tx *Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cn, err := c.get()
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	cn.netConn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		cn.netConn.SetDeadline(time.Now())
+	})
+
+	tx := &Tx{cn: cn}
+	err = tx.run(keys, fn)
+	if !stop() && tx.broken {
+		err = ctx.Err()
+	}
+	if tx.broken {
+		cn.netConn.Close()
+		return err
+	}
+	c.put(cn)
+	return err
+}
+
+func (tx *Tx) run(keys []string, fn func(tx *Tx) error) error {
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		if _, err := tx.Do(append([]string{"WATCH"}, keys...)...); err != nil {
+			return err
+		}
+		tx.executed = false
+		err := fn(tx)
+		// keys stay watched when fn returns before Exec
+		if !tx.executed && !tx.broken {
+			if _, unwatchErr := tx.Do("UNWATCH"); err == nil {
+				err = unwatchErr
+			}
+		}
+		if !errors.Is(err, ErrTxAborted) {
+			return err
+		}
+	}
+	return ErrTxAborted
+}
+
+// send command on connection of transaction and read reply, error replies are returned as Error
+func (tx *Tx) Do(args ...string) (interface{}, error) {
+	replies, err := tx.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// send batch of commands on connection of transaction in one round trip
+func (tx *Tx) Pipeline(cmds [][]string) ([]interface{}, error) {
+	replies, err := tx.cn.pipeline(cmds)
+	if err != nil {
+		tx.broken = true
+		return nil, err
+	}
+	return replies, nil
+}
+
+// apply commands atomically and return their replies, ErrTxAborted when watched key was changed
+func (tx *Tx) Exec(cmds [][]string) ([]interface{}, error) {
+	tx.executed = true
+	replies, err := tx.Pipeline(append(append([][]string{{"MULTI"}}, cmds...), []string{"EXEC"}))
+	if err != nil {
+		return nil, err
+	}
+	// commands are queued by server, invalid command aborts whole transaction
+	if err := FirstError(replies[:len(replies)-1]); err != nil {
+		return nil, err
+	}
+	switch result := replies[len(replies)-1].(type) {
+	case nil:
+		return nil, ErrTxAborted
+	case Error:
+		return nil, result
+	case []interface{}:
+		return result, nil
+	}
+	return nil, ErrUnexpectedReply
+}
+
+// close idle connections
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.netConn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (cn *conn) pipeline(cmds [][]string) ([]interface{}, error) {
+	for _, cmd := range cmds {
+		if err := WriteCommand(cn.writer, cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, 0, len(cmds))
+	for range cmds {
+		reply, err := ReadReply(cn.reader)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// write command as array of bulk strings
+func WriteCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read one reply: string, int64, nil, Error or []interface{}
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrUnexpectedReply
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		array := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			item, err := ReadReply(r)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, item)
+		}
+		return array, nil
+	}
+	return nil, ErrUnexpectedReply
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// get first error reply from pipeline replies
+func FirstError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// convert reply to string, nil reply is returned as empty string with ok false
+func String(reply interface{}) (string, bool, error) {
+	switch v := reply.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, true, nil
+	case Error:
+		return "", false, v
+	}
+	return "", false, ErrUnexpectedReply
+}
+
+// convert reply to int64
+func Int(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case Error:
+		return 0, v
+	}
+	return 0, ErrUnexpectedReply
+}
+
+// convert array reply to strings, nil items are returned as empty strings
+func Strings(reply interface{}) ([]string, error) {
+	switch v := reply.(type) {
+	case nil:
+		return nil, nil
+	case Error:
+		return nil, v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			s, _, err := String(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+	return nil, ErrUnexpectedReply
+}
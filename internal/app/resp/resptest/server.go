@@ -0,0 +1,475 @@
+// resptest package provides in-process RESP server for tests, it keeps data in memory
+// and supports subset of Redis commands for strings, hashes and sets with MULTI/EXEC transactions
+package resptest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rutkin/url-shortener/internal/app/resp"
+)
+
+// Server - RESP stand-in server listening on loopback interface
+type Server struct {
+	listener net.Listener
+	mu       sync.Mutex
+	strings  map[string]string
+	hashes   map[string]map[string]string
+	sets     map[string]map[string]struct{}
+	// version of key is changed on every write to it, WATCH compares versions
+	versions map[string]int64
+	writes   int64
+	wg       sync.WaitGroup
+}
+
+// transaction state of connection
+type session struct {
+	multi   bool
+	queue   [][]string
+	watched map[string]int64
+}
+
+// commands changing keys, DEL changes every argument, others change first one
+var writeCommands = map[string]bool{
+	"SET": true, "INCRBY": true, "DEL": true, "HSET": true, "HSETNX": true,
+	"HDEL": true, "HINCRBY": true, "SADD": true, "SREM": true,
+}
+
+// start new server on random port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, versions: make(map[string]int64)}
+	s.flush()
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// server address in host:port form
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// stop server
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) flush() {
+	for key := range s.versions {
+		s.touch(key)
+	}
+	s.strings = make(map[string]string)
+	s.hashes = make(map[string]map[string]string)
+	s.sets = make(map[string]map[string]struct{})
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	sess := &session{}
+	for {
+		reply, err := resp.ReadReply(reader)
+		if err != nil {
+			return
+		}
+		args, err := resp.Strings(reply)
+		if err != nil || len(args) == 0 {
+			writeReply(writer, resp.Error("ERR invalid command"))
+		} else {
+			writeReply(writer, s.execSession(sess, strings.ToUpper(args[0]), args[1:]))
+		}
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeReply(w io.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		fmt.Fprint(w, "$-1\r\n")
+	case resp.Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+func errArgs(cmd string) resp.Error {
+	return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func stringsReply(values []string) []interface{} {
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		result = append(result, v)
+	}
+	return result
+}
+
+// handle transaction commands of connection, other commands are queued inside MULTI
+func (s *Server) execSession(sess *session, cmd string, args []string) interface{} {
+	switch cmd {
+	case "WATCH":
+		if sess.multi {
+			return resp.Error("ERR WATCH inside MULTI is not allowed")
+		}
+		if len(args) == 0 {
+			return errArgs(cmd)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess.watched == nil {
+			sess.watched = make(map[string]int64)
+		}
+		for _, key := range args {
+			if _, ok := sess.watched[key]; !ok {
+				sess.watched[key] = s.versions[key]
+			}
+		}
+		return "OK"
+	case "UNWATCH":
+		sess.watched = nil
+		return "OK"
+	case "MULTI":
+		if sess.multi {
+			return resp.Error("ERR MULTI calls can not be nested")
+		}
+		sess.multi = true
+		return "OK"
+	case "DISCARD":
+		if !sess.multi {
+			return resp.Error("ERR DISCARD without MULTI")
+		}
+		*sess = session{}
+		return "OK"
+	case "EXEC":
+		if !sess.multi {
+			return resp.Error("ERR EXEC without MULTI")
+		}
+		queue, watched := sess.queue, sess.watched
+		*sess = session{}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, version := range watched {
+			if s.versions[key] != version {
+				return nil
+			}
+		}
+		result := make([]interface{}, 0, len(queue))
+		for _, args := range queue {
+			result = append(result, s.apply(args[0], args[1:]))
+		}
+		return result
+	}
+	if sess.multi {
+		sess.queue = append(sess.queue, append([]string{cmd}, args...))
+		return "QUEUED"
+	}
+	return s.exec(cmd, args)
+}
+
+func (s *Server) exec(cmd string, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(cmd, args)
+}
+
+// execute command and change versions of written keys
+func (s *Server) apply(cmd string, args []string) interface{} {
+	reply := s.applyCommand(cmd, args)
+	if _, failed := reply.(resp.Error); failed || !writeCommands[cmd] {
+		return reply
+	}
+	if cmd == "DEL" {
+		for _, key := range args {
+			s.touch(key)
+		}
+	} else {
+		s.touch(args[0])
+	}
+	return reply
+}
+
+func (s *Server) touch(key string) {
+	s.writes++
+	s.versions[key] = s.writes
+}
+
+func (s *Server) applyCommand(cmd string, args []string) interface{} {
+	switch cmd {
+	case "PING":
+		return "PONG"
+	case "FLUSHALL", "FLUSHDB":
+		s.flush()
+		return "OK"
+	case "GET":
+		if len(args) != 1 {
+			return errArgs(cmd)
+		}
+		if v, ok := s.strings[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "SET":
+		if len(args) != 2 {
+			return errArgs(cmd)
+		}
+		s.strings[args[0]] = args[1]
+		return "OK"
+	case "INCRBY":
+		if len(args) != 2 {
+			return errArgs(cmd)
+		}
+		return s.incr(s.strings, args[0], args[1])
+	case "EXISTS":
+		if len(args) == 0 {
+			return errArgs(cmd)
+		}
+		var count int64
+		for _, key := range args {
+			_, isString := s.strings[key]
+			_, isHash := s.hashes[key]
+			_, isSet := s.sets[key]
+			if isString || isHash || isSet {
+				count++
+			}
+		}
+		return count
+	case "DEL":
+		var count int64
+		for _, key := range args {
+			_, isString := s.strings[key]
+			_, isHash := s.hashes[key]
+			_, isSet := s.sets[key]
+			if isString || isHash || isSet {
+				count++
+			}
+			delete(s.strings, key)
+			delete(s.hashes, key)
+			delete(s.sets, key)
+		}
+		return count
+	case "HSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errArgs(cmd)
+		}
+		hash := s.hash(args[0])
+		var count int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				count++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return count
+	case "HSETNX":
+		if len(args) != 3 {
+			return errArgs(cmd)
+		}
+		hash := s.hash(args[0])
+		if _, ok := hash[args[1]]; ok {
+			return int64(0)
+		}
+		hash[args[1]] = args[2]
+		return int64(1)
+	case "HGET":
+		if len(args) != 2 {
+			return errArgs(cmd)
+		}
+		if v, ok := s.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "HMGET":
+		if len(args) < 2 {
+			return errArgs(cmd)
+		}
+		result := make([]interface{}, 0, len(args)-1)
+		for _, field := range args[1:] {
+			if v, ok := s.hashes[args[0]][field]; ok {
+				result = append(result, v)
+			} else {
+				result = append(result, nil)
+			}
+		}
+		return result
+	case "HGETALL":
+		if len(args) != 1 {
+			return errArgs(cmd)
+		}
+		hash := s.hashes[args[0]]
+		fields := make([]string, 0, len(hash))
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		result := make([]interface{}, 0, 2*len(hash))
+		for _, field := range fields {
+			result = append(result, field, hash[field])
+		}
+		return result
+	case "HDEL":
+		if len(args) < 2 {
+			return errArgs(cmd)
+		}
+		var count int64
+		for _, field := range args[1:] {
+			if _, ok := s.hashes[args[0]][field]; ok {
+				delete(s.hashes[args[0]], field)
+				count++
+			}
+		}
+		return count
+	case "HINCRBY":
+		if len(args) != 3 {
+			return errArgs(cmd)
+		}
+		return s.incr(s.hash(args[0]), args[1], args[2])
+	case "SADD":
+		if len(args) < 2 {
+			return errArgs(cmd)
+		}
+		set, ok := s.sets[args[0]]
+		if !ok {
+			set = make(map[string]struct{})
+			s.sets[args[0]] = set
+		}
+		var count int64
+		for _, member := range args[1:] {
+			if _, ok := set[member]; !ok {
+				set[member] = struct{}{}
+				count++
+			}
+		}
+		return count
+	case "SREM":
+		if len(args) < 2 {
+			return errArgs(cmd)
+		}
+		var count int64
+		for _, member := range args[1:] {
+			if _, ok := s.sets[args[0]][member]; ok {
+				delete(s.sets[args[0]], member)
+				count++
+			}
+		}
+		return count
+	case "SISMEMBER":
+		if len(args) != 2 {
+			return errArgs(cmd)
+		}
+		if _, ok := s.sets[args[0]][args[1]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "SCARD":
+		if len(args) != 1 {
+			return errArgs(cmd)
+		}
+		return int64(len(s.sets[args[0]]))
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return errArgs(cmd)
+		}
+		members := make([]string, 0, len(s.sets[args[0]]))
+		for member := range s.sets[args[0]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return stringsReply(members)
+	case "SSCAN":
+		// cursor is offset in sorted members, MATCH is not supported
+		if len(args) != 2 && len(args) != 4 {
+			return errArgs(cmd)
+		}
+		offset, err := strconv.Atoi(args[1])
+		if err != nil || offset < 0 {
+			return resp.Error("ERR invalid cursor")
+		}
+		count := 10
+		if len(args) == 4 {
+			if !strings.EqualFold(args[2], "COUNT") {
+				return resp.Error("ERR syntax error")
+			}
+			if count, err = strconv.Atoi(args[3]); err != nil || count <= 0 {
+				return resp.Error("ERR value is not an integer or out of range")
+			}
+		}
+		members := make([]string, 0, len(s.sets[args[0]]))
+		for member := range s.sets[args[0]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		offset = min(offset, len(members))
+		end := min(offset+count, len(members))
+		next := "0"
+		if end < len(members) {
+			next = strconv.Itoa(end)
+		}
+		return []interface{}{next, stringsReply(members[offset:end])}
+	}
+	return resp.Error(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+}
+
+func (s *Server) hash(key string) map[string]string {
+	hash, ok := s.hashes[key]
+	if !ok {
+		hash = make(map[string]string)
+		s.hashes[key] = hash
+	}
+	return hash
+}
+
+func (s *Server) incr(values map[string]string, key string, by string) interface{} {
+	delta, err := strconv.ParseInt(by, 10, 64)
+	if err != nil {
+		return resp.Error("ERR value is not an integer or out of range")
+	}
+	var current int64
+	if v, ok := values[key]; ok {
+		current, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return resp.Error("ERR hash value is not an integer")
+		}
+	}
+	current += delta
+	values[key] = strconv.FormatInt(current, 10)
+	return current
+}
@@ -10,63 +10,106 @@ import (
 	"syscall"
 
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/rutkin/url-shortener/internal/app/config"
 	"github.com/rutkin/url-shortener/internal/app/handlers"
 	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/metrics"
 	"github.com/rutkin/url-shortener/internal/app/middleware"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/service"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/grpc"
 )
 
-// create new instance of server
-func NewServer() (*Server, error) {
-	handler, err := handlers.NewURLHandler()
+// create new instance of server, http and grpc handlers share one service
+func NewServer(build models.BuildInfo) (*Server, error) {
+	s, err := service.NewURLService()
+	if err != nil {
+		logger.Log.Error("failed to create url service", zap.String("error", err.Error()))
+		return nil, err
+	}
+	handler, err := handlers.NewURLHandler(s)
 	if err != nil {
 		logger.Log.Error("failed to create url handler", zap.String("error", err.Error()))
+		s.Close()
+		return nil, err
+	}
+	accessLogger, err := middleware.NewAccessLogger(middleware.AccessLogOptions{
+		Format:           config.ServerConfig.AccessLogFormat,
+		Filename:         config.ServerConfig.AccessLogFile,
+		MaxSizeMB:        config.ServerConfig.AccessLogMaxSize,
+		MaxBackups:       config.ServerConfig.AccessLogMaxBackups,
+		RedirectSampling: config.ServerConfig.AccessLogRedirectSampling,
+	})
+	if err != nil {
+		logger.Log.Error("failed to create access logger", zap.String("error", err.Error()))
+		s.Close()
 		return nil, err
 	}
-	return &Server{handler}, nil
+	metrics.SetBuildInfo(build.Version, build.Commit)
+	return &Server{s, handler, handlers.NewGRPCHandler(s), handlers.NewAdminHandler(s, build), accessLogger}, nil
 }
 
 // server type
 type Server struct {
-	urlHandler *handlers.URLHandler
+	service      service.Service
+	urlHandler   *handlers.URLHandler
+	grpcHandler  *handlers.GRPCHanlder
+	adminHandler *handlers.AdminHandler
+	accessLogger *middleware.AccessLogger
 }
 
-// start
+// start servers and wait until they are stopped by signal
 func (s Server) Start() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	starters := []func(ctx context.Context) error{s.startGRPC, s.startHTTP}
+	if config.ServerConfig.AdminAddress != "" {
+		starters = append(starters, s.startAdmin)
+	}
+
 	wg := sync.WaitGroup{}
-	wg.Add(2)
-	go func() {
-		s.startGRPC()
-		wg.Done()
-	}()
-	go func() {
-		s.startHTTP()
-		wg.Done()
-	}()
+	wg.Add(len(starters))
+	for _, start := range starters {
+		go func(start func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := start(ctx); err != nil {
+				stop()
+			}
+		}(start)
+	}
 	wg.Wait()
 	return nil
 }
 
-// start http server
-func (s Server) startHTTP() error {
-	logger.Log.Info("Running server", zap.String("address", config.ServerConfig.Server.String()))
-
-	var srv *http.Server
-
+// serve http server until context is done
+func serveHTTP(ctx context.Context, srv *http.Server, serve func() error) error {
 	idleConnsClosed := make(chan struct{})
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
-		<-sigint
+		<-ctx.Done()
 		if err := srv.Shutdown(context.Background()); err != nil {
 			logger.Log.Info("HTTP server Shutdown: ", zap.String("error", err.Error()))
 		}
 		close(idleConnsClosed)
 	}()
 
+	err := serve()
+	if err == http.ErrServerClosed {
+		<-idleConnsClosed
+		return nil
+	}
+	logger.Log.Error("failed to serve http", zap.String("address", srv.Addr), zap.String("error", err.Error()))
+	return err
+}
+
+// start http server
+func (s Server) startHTTP(ctx context.Context) error {
+	logger.Log.Info("Running server", zap.String("address", config.ServerConfig.Server.String()))
+
 	var err error
 	if config.ServerConfig.EnableHTTPS {
 		manager := &autocert.Manager{
@@ -74,41 +117,47 @@ func (s Server) startHTTP() error {
 			Prompt: autocert.AcceptTOS,
 		}
 
-		srv = &http.Server{
+		srv := &http.Server{
 			Addr:      ":443",
 			Handler:   s.newRootRouter(),
 			TLSConfig: manager.TLSConfig(),
 		}
-		err = srv.ListenAndServeTLS("", "")
+		err = serveHTTP(ctx, srv, func() error { return srv.ListenAndServeTLS("", "") })
 	} else {
-		srv = &http.Server{Addr: config.ServerConfig.Server.String(), Handler: s.newRootRouter()}
-		err = srv.ListenAndServe()
+		srv := &http.Server{Addr: config.ServerConfig.Server.String(), Handler: s.newRootRouter()}
+		err = serveHTTP(ctx, srv, srv.ListenAndServe)
 	}
 
-	<-idleConnsClosed
-
 	logger.Log.Info("Server stopped")
+	return err
+}
 
-	if err == http.ErrServerClosed {
-		return nil
-	}
-
+// start admin http server
+func (s Server) startAdmin(ctx context.Context) error {
+	logger.Log.Info("Running admin server", zap.String("address", config.ServerConfig.AdminAddress))
+	srv := &http.Server{Addr: config.ServerConfig.AdminAddress, Handler: s.newAdminRouter()}
+	err := serveHTTP(ctx, srv, srv.ListenAndServe)
+	logger.Log.Info("Admin server stopped")
 	return err
 }
 
 // start grpc server
-func (s Server) startGRPC() error {
+func (s Server) startGRPC(ctx context.Context) error {
 	listen, err := net.Listen("tcp", ":3200")
 	if err != nil {
 		logger.Log.Error("failed to listen tcp server", zap.String("error", err.Error()))
 		return err
 	}
-	grpcServer := grpc.NewServer()
-	grpcHandler, err := handlers.NewGRPCHandler()
-	if err != nil {
-		return err
-	}
-	handlers.RegisterGRPCHandlerServer(grpcServer, grpcHandler)
+	grpcServer := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.UnaryInterceptor(metrics.UnaryServerInterceptor),
+		grpc.StreamInterceptor(metrics.StreamServerInterceptor),
+	)
+	handlers.RegisterGRPCHandlerServer(grpcServer, s.grpcHandler)
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
 	if err := grpcServer.Serve(listen); err != nil {
 		logger.Log.Error("failed to serve grpc", zap.String("error", err.Error()))
 		return err
@@ -118,21 +167,49 @@ func (s Server) startGRPC() error {
 
 // close
 func (s Server) Close() error {
-	return s.urlHandler.Close()
+	err := s.service.Close()
+	s.accessLogger.Close()
+	return err
 }
 
 func (s Server) newRootRouter() http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.WithLogging)
+	r.Use(middleware.WithTracing)
+	r.Use(middleware.WithRequestID)
+	r.Use(middleware.WithMetrics)
+	r.Use(s.accessLogger.Handler)
 	r.Use(middleware.WithCompress)
 	userIDRouter := r.With(middleware.WithUserID)
 	userIDRouter.Post("/", handlers.NewHandler(s.urlHandler.CreateURLWithTextBody))
 	userIDRouter.Get("/{id}", handlers.NewHandler(s.urlHandler.GetURL))
+	userIDRouter.Post("/{id}/unlock", handlers.NewHandler(s.urlHandler.UnlockURL))
 	userIDRouter.Post("/api/shorten", handlers.NewHandler(s.urlHandler.CreateShortenWithJSONBody))
 	userIDRouter.Post("/api/shorten/batch", handlers.NewHandler(s.urlHandler.CreateBatch))
-	userIDRouter.Get("/ping", s.urlHandler.PingDB)
 	userIDRouter.Delete("/api/user/urls", handlers.NewHandler(s.urlHandler.DeleteURLS))
 	userIDRouter.Get("/api/internal/stats", handlers.NewHandler(s.urlHandler.GetStats))
-	r.With(middleware.WithAuth).Get("/api/user/urls", handlers.NewHandler(s.urlHandler.GetURLS))
+	userIDRouter.Get("/api/internal/stats/timeseries", handlers.NewHandler(s.urlHandler.GetTimeSeries))
+	authRouter := r.With(middleware.WithAuth)
+	authRouter.Get("/api/user/urls", handlers.NewHandler(s.urlHandler.GetURLS))
+	authRouter.Get("/api/user/urls/export", handlers.NewHandler(s.urlHandler.ExportURLS))
+	authRouter.Post("/api/user/urls/import", handlers.NewHandler(s.urlHandler.ImportURLS))
+	authRouter.Patch("/api/user/urls/{id}", handlers.NewHandler(s.urlHandler.UpdateURL))
+	authRouter.Get("/api/user/urls/{id}/revisions", handlers.NewHandler(s.urlHandler.GetRevisions))
+	authRouter.Post("/api/user/urls/{id}/rollback", handlers.NewHandler(s.urlHandler.RollbackURL))
+	r.Get("/ping", s.urlHandler.Ping)
+	return r
+}
+
+func (s Server) newAdminRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.WithRequestID)
+	r.Handle("/metrics", metrics.Handler())
+	r.Get("/healthz", s.adminHandler.Healthz)
+	r.Get("/readyz", s.adminHandler.Readyz)
+	r.Get("/version", s.adminHandler.BuildInfo)
+	r.Get("/policy/domains", s.adminHandler.DomainRules)
+	r.Post("/policy/domains", s.adminHandler.AddDomainRule)
+	r.Delete("/policy/domains", s.adminHandler.RemoveDomainRule)
+	r.Post("/policy/domains/disable", s.adminHandler.DisableBlockedURLS)
+	r.Mount("/debug", chimiddleware.Profiler())
 	return r
 }
@@ -3,18 +3,50 @@ package app
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rutkin/url-shortener/internal/app/config"
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/resp/resptest"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
+// tests share storage file in temporary directory instead of default one
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "shortener")
+	if err != nil {
+		panic(err)
+	}
+	filename := filepath.Join(dir, "short-url-db.json")
+	os.Setenv("FILE_STORAGE_PATH", filename)
+	config.ServerConfig.FileStoragePath = filename
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, body string, contentType string, headers map[string]string) (int, string) {
 	var reader io.Reader
 	if body != "" {
@@ -48,7 +80,7 @@ func TestRootRouter(t *testing.T) {
 	t.Setenv("TRUSTED_SUBNET", "127.0.0.1/32")
 	err := config.ParseFlags()
 	require.NoError(t, err)
-	server, err := NewServer()
+	server, err := NewServer(models.BuildInfo{})
 	require.NoError(t, err)
 	defer server.Close()
 
@@ -110,6 +142,23 @@ func TestRootRouter(t *testing.T) {
 			expectedCode: http.StatusTemporaryRedirect,
 			location:     "https://go.dev",
 		},
+		{
+			name:         "method_post_normalized_url",
+			method:       http.MethodPost,
+			path:         "/",
+			contentType:  "text/plain; charset=utf-8",
+			requestBody:  " HTTPS://GO.dev:443?utm_source=mail\n",
+			expectedCode: http.StatusConflict,
+			expectedBody: "http://localhost:8080/D292748E",
+		},
+		{
+			name:         "method_post_unsupported_scheme",
+			method:       http.MethodPost,
+			path:         "/",
+			contentType:  "text/plain; charset=utf-8",
+			requestBody:  "javascript:alert(1)",
+			expectedCode: http.StatusBadRequest,
+		},
 		{
 			name:         "method_post_shorten_unsupported_body",
 			method:       http.MethodPost,
@@ -149,7 +198,8 @@ func TestRootRouter(t *testing.T) {
 			contentType:  "application/json",
 			expectedCode: http.StatusOK,
 			headers:      map[string]string{"X-Real-IP": "127.0.0.1"},
-			expectedBody: `{"urls":3,"users":2}
+			expectedBody: `{"urls":2,"users":1,"active_urls":2,"deleted_urls":0,"created_last_day":2,"created_last_week":2,"redirects":1,` +
+				`"top_domains":[{"domain":"go.dev","urls":1},{"domain":"testurl.com","urls":1}]}
 `,
 		},
 	}
@@ -167,15 +217,15 @@ func TestRootRouter(t *testing.T) {
 }
 
 func TestCompression(t *testing.T) {
-	server, err := NewServer()
+	server, err := NewServer(models.BuildInfo{})
 	require.NoError(t, err)
 	defer server.Close()
 
 	ts := httptest.NewServer(server.newRootRouter())
 	defer ts.Close()
 
-	requestBody := `{"url": "https://testurl.com/blablabla"}`
-	expectedBody := `{"result":"http://localhost:8080/9718264F"}`
+	requestBody := `{"url": "https://testurl.com/compressed"}`
+	expectedBody := `{"result":"http://localhost:8080/58671520"}`
 
 	t.Run("sends_gzip", func(t *testing.T) {
 		buf := bytes.NewBuffer(nil)
@@ -205,3 +255,1065 @@ func TestCompression(t *testing.T) {
 		require.JSONEq(t, expectedBody, string(b))
 	})
 }
+
+func TestAdminRouter(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{Version: "v1.2.3", Date: "2024-01-02", Commit: "abc123"})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+	admin := httptest.NewServer(server.newAdminRouter())
+	defer admin.Close()
+
+	status, _ := testRequest(t, ts, http.MethodPost, "/", "https://admin.example.com/metrics", "text/plain; charset=utf-8", nil)
+	require.Equal(t, http.StatusCreated, status)
+
+	t.Run("metrics", func(t *testing.T) {
+		status, body := testRequest(t, admin, http.MethodGet, "/metrics", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, `shortener_http_requests_total{method="POST",route="/",status="201"}`)
+		assert.Contains(t, body, `shortener_storage_operation_duration_seconds_count{backend="file",operation="create_url"}`)
+		assert.Contains(t, body, `shortener_build_info{commit="abc123",version="v1.2.3"} 1`)
+		assert.Contains(t, body, `shortener_cache_hits_total`)
+		assert.Contains(t, body, `shortener_cache_misses_total`)
+	})
+
+	t.Run("probes", func(t *testing.T) {
+		status, body := testRequest(t, admin, http.MethodGet, "/healthz", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ok", body)
+
+		status, body = testRequest(t, admin, http.MethodGet, "/readyz", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "ok", body)
+	})
+
+	t.Run("build_info", func(t *testing.T) {
+		status, body := testRequest(t, admin, http.MethodGet, "/version", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"version":"v1.2.3","date":"2024-01-02","commit":"abc123"}`, body)
+	})
+
+	t.Run("pprof", func(t *testing.T) {
+		status, body := testRequest(t, admin, http.MethodGet, "/debug/pprof/cmdline", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.NotEmpty(t, body)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	_, err := tracing.Initialize(context.Background(), "", "", "", "test")
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	testRequest(t, ts, http.MethodGet, "/ABCDEF", "", "", map[string]string{
+		"traceparent": "00-" + traceID + "-" + parentID + "-01",
+	})
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, traceID, span.SpanContext().TraceID().String())
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "GET /{id}")
+	require.Contains(t, spans, "urlService.GetURL")
+	assert.Equal(t, parentID, spans["GET /{id}"].Parent().SpanID().String())
+	assert.Equal(t, spans["GET /{id}"].SpanContext().SpanID(), spans["urlService.GetURL"].Parent().SpanID())
+}
+
+func TestRequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	previous := logger.Log
+	logger.Log = zap.New(core)
+	defer func() { logger.Log = previous }()
+
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	t.Run("accepted_from_header", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/ABCDEF", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-ID", "test-request-1")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "test-request-1", resp.Header.Get("X-Request-ID"))
+
+		entries := logs.FilterMessage("failed to get url by id").AllUntimed()
+		require.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "test-request-1", fields["request_id"])
+		assert.Equal(t, "/{id}", fields["route"])
+		assert.NotEmpty(t, fields["user_id"])
+		assert.NotEmpty(t, fields["remote_addr"])
+	})
+
+	t.Run("generated_for_invalid_header", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/ABCDEF", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-ID", "bad id <script>")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		requestID := resp.Header.Get("X-Request-ID")
+		assert.NotEqual(t, "bad id <script>", requestID)
+		assert.Len(t, requestID, 36)
+	})
+}
+
+func TestPing(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodGet, "/ping", "", "", nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	var report models.HealthReport
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, models.HealthOK, report.Status)
+	require.Len(t, report.Components, 1)
+	assert.Equal(t, "storage", report.Components[0].Name)
+	assert.Equal(t, "file", report.Components[0].Backend)
+	assert.Equal(t, models.HealthOK, report.Components[0].Status)
+}
+
+func TestTimeSeries(t *testing.T) {
+	trustedSubnet := config.ServerConfig.TrustedSubnet
+	config.ServerConfig.TrustedSubnet = "127.0.0.1/32"
+	defer func() { config.ServerConfig.TrustedSubnet = trustedSubnet }()
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/", "https://timeseries.example/a", "text/plain; charset=utf-8", nil)
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(body, config.ServerConfig.Base.String()), "", "", nil)
+	require.Equal(t, http.StatusTemporaryRedirect, status)
+
+	today := time.Now().UTC().Format(models.DayLayout)
+	trusted := map[string]string{"X-Real-IP": "127.0.0.1"}
+
+	t.Run("json", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodGet, "/api/internal/stats/timeseries?bucket=day&from="+today+"&to="+today, "", "", trusted)
+		require.Equal(t, http.StatusOK, status)
+
+		var points []models.TimeSeriesPoint
+		require.NoError(t, json.Unmarshal([]byte(body), &points))
+		var found []models.TimeSeriesPoint
+		for _, p := range points {
+			if p.Domain == "timeseries.example" {
+				p.UserID = ""
+				found = append(found, p)
+			}
+		}
+		assert.Equal(t, []models.TimeSeriesPoint{{Day: today, Domain: "timeseries.example", Created: 1, Redirects: 1}}, found)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodGet, "/api/internal/stats/timeseries", "", "",
+			map[string]string{"X-Real-IP": "127.0.0.1", "Accept": "text/csv"})
+		require.Equal(t, http.StatusOK, status)
+		assert.True(t, strings.HasPrefix(body, "day,user_id,domain,created,redirects\n"))
+		assert.Contains(t, body, ",timeseries.example,1,1\n")
+	})
+
+	t.Run("unsupported_bucket", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodGet, "/api/internal/stats/timeseries?bucket=hour", "", "", trusted)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodGet, "/api/internal/stats/timeseries", "", "", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+}
+
+func TestDomainPolicy(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+	admin := httptest.NewServer(server.newAdminRouter())
+	defer admin.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/", "https://phishing.test/login", "text/plain; charset=utf-8", nil)
+	require.Equal(t, http.StatusCreated, status)
+	path := strings.TrimPrefix(body, config.ServerConfig.Base.String())
+	rule := `{"action":"block","pattern":"Phishing.test"}`
+
+	t.Run("block", func(t *testing.T) {
+		status, _ := testRequest(t, admin, http.MethodPost, "/policy/domains", rule, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		status, body := testRequest(t, admin, http.MethodGet, "/policy/domains", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `[{"action":"block","pattern":"phishing.test"}]`, body)
+
+		status, _ = testRequest(t, ts, http.MethodPost, "/", "https://phishing.test/other", "text/plain; charset=utf-8", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("disable_existing", func(t *testing.T) {
+		status, body := testRequest(t, admin, http.MethodPost, "/policy/domains/disable", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"disabled":1}`, body)
+
+		status, _ = testRequest(t, admin, http.MethodDelete, "/policy/domains", rule, "application/json", nil)
+		require.Equal(t, http.StatusNoContent, status)
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusGone, status)
+	})
+
+	t.Run("invalid_rules", func(t *testing.T) {
+		status, _ := testRequest(t, admin, http.MethodDelete, "/policy/domains", rule, "application/json", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = testRequest(t, admin, http.MethodPost, "/policy/domains", `{"action":"deny","pattern":"evil.com"}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+func TestReputation(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			URLS []string `json:"urls"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		var matches []map[string]string
+		for _, url := range req.URLS {
+			if strings.Contains(url, "malware.test") {
+				matches = append(matches, map[string]string{"url": url, "threat": "MALWARE"})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"matches": matches})
+	}))
+	defer stub.Close()
+
+	saved := config.ServerConfig
+	config.ServerConfig.ReputationProvider = "http"
+	config.ServerConfig.ReputationEndpoint = stub.URL
+	config.ServerConfig.CacheSize = 0
+	defer func() { config.ServerConfig = saved }()
+
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/", "https://malware.test/download", "text/plain; charset=utf-8", nil)
+	require.Equal(t, http.StatusCreated, status)
+	flagged := strings.TrimPrefix(body, config.ServerConfig.Base.String())
+	status, body = testRequest(t, ts, http.MethodPost, "/", "https://go.dev/reputation", "text/plain; charset=utf-8", nil)
+	require.Equal(t, http.StatusCreated, status)
+	safe := strings.TrimPrefix(body, config.ServerConfig.Base.String())
+
+	assert.Eventually(t, func() bool {
+		status, _ := testRequest(t, ts, http.MethodGet, flagged, "", "", nil)
+		return status == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	status, body = testRequest(t, ts, http.MethodGet, flagged, "", "", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<strong>MALWARE</strong>")
+	assert.Contains(t, body, `href="https://malware.test/download"`)
+
+	status, _ = testRequest(t, ts, http.MethodGet, safe, "", "", nil)
+	assert.Equal(t, http.StatusTemporaryRedirect, status)
+}
+
+func TestPreview(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://preview.example/doc","title":"Design <doc>"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var created models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	path := strings.TrimPrefix(created.Result, config.ServerConfig.Base.String())
+
+	t.Run("html", func(t *testing.T) {
+		for _, previewPath := range []string{path + "+", path + "?preview=1"} {
+			status, body := testRequest(t, ts, http.MethodGet, previewPath, "", "", nil)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Contains(t, body, "<h1>Design &lt;doc&gt;</h1>")
+			assert.Contains(t, body, `href="https://preview.example/doc"`)
+			assert.Contains(t, body, "Created on "+time.Now().UTC().Format(models.DayLayout))
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodGet, path+"+", "", "", map[string]string{"Accept": "application/json"})
+		require.Equal(t, http.StatusOK, status)
+		var preview models.URLPreview
+		require.NoError(t, json.Unmarshal([]byte(body), &preview))
+		assert.Equal(t, created.Result, preview.ShortURL)
+		assert.Equal(t, "https://preview.example/doc", preview.OriginalURL)
+		assert.Equal(t, "Design <doc>", preview.Title)
+	})
+
+	t.Run("redirect_without_preview", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+	})
+
+	t.Run("forced_preview", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://preview.example/always","preview":true}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+		status, body = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(created.Result, config.ServerConfig.Base.String()), "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "<h1>Link preview</h1>")
+		assert.Contains(t, body, `href="https://preview.example/always"`)
+	})
+
+	t.Run("forced_preview_of_shortened_url", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://preview.example/shared"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://preview.example/shared","preview":true}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+		status, body = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(created.Result, config.ServerConfig.Base.String()), "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "<h1>Link preview</h1>")
+	})
+
+	t.Run("title_too_long", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten",
+			`{"url":"https://preview.example/long","title":"`+strings.Repeat("a", 256)+`"}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodGet, "/FFFFFFFF+", "", "", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+func TestRedirectType(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		redirectType int
+		status       int
+		cacheControl string
+	}{
+		{name: "default", status: http.StatusTemporaryRedirect, cacheControl: "private, no-cache"},
+		{name: "moved_permanently", redirectType: 301, status: http.StatusMovedPermanently, cacheControl: "public, max-age=86400"},
+		{name: "found", redirectType: 302, status: http.StatusFound, cacheControl: "private, no-cache"},
+		{name: "permanent_redirect", redirectType: 308, status: http.StatusPermanentRedirect, cacheControl: "public, max-age=86400"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := json.Marshal(models.Request{
+				URL:        "https://redirects.example/" + tt.name,
+				URLOptions: models.URLOptions{RedirectType: tt.redirectType},
+			})
+			require.NoError(t, err)
+			status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", string(request), "application/json", nil)
+			require.Equal(t, http.StatusCreated, status)
+			var created models.Response
+			require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+strings.TrimPrefix(created.Result, config.ServerConfig.Base.String()), nil)
+			require.NoError(t, err)
+			resp, err := ts.Client().Transport.RoundTrip(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, "https://redirects.example/"+tt.name, resp.Header.Get("Location"))
+			assert.Equal(t, tt.cacheControl, resp.Header.Get("Cache-Control"))
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://redirects.example/invalid","redirect_type":200}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("shortened_url", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://redirects.example/shared"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://redirects.example/shared","redirect_type":301}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var created models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+strings.TrimPrefix(created.Result, config.ServerConfig.Base.String()), nil)
+		require.NoError(t, err)
+		resp, err := ts.Client().Transport.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	})
+}
+
+func TestPasswordProtected(t *testing.T) {
+	maxAttempts := config.ServerConfig.PasswordMaxAttempts
+	config.ServerConfig.PasswordMaxAttempts = 2
+	defer func() { config.ServerConfig.PasswordMaxAttempts = maxAttempts }()
+
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://internal.example/doc","password":"secret"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var created models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	path := strings.TrimPrefix(created.Result, config.ServerConfig.Base.String())
+	form := "application/x-www-form-urlencoded"
+
+	t.Run("password_form", func(t *testing.T) {
+		for _, formPath := range []string{path, path + "+"} {
+			status, body := testRequest(t, ts, http.MethodGet, formPath, "", "", nil)
+			assert.Equal(t, http.StatusUnauthorized, status)
+			assert.Contains(t, body, `action="`+created.Result+`/unlock"`)
+			assert.NotContains(t, body, "internal.example")
+		}
+	})
+
+	t.Run("header", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodGet, path, "", "", map[string]string{"X-Link-Password": "wrong"})
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", map[string]string{"X-Link-Password": "secret"})
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+	})
+
+	t.Run("unlock_form", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, path+"/unlock", "password=secret", form, nil)
+		assert.Equal(t, http.StatusSeeOther, status)
+	})
+
+	t.Run("throttling", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			status, body := testRequest(t, ts, http.MethodPost, path+"/unlock", "password=wrong", form, nil)
+			assert.Equal(t, http.StatusUnauthorized, status)
+			assert.Contains(t, body, "Wrong password")
+		}
+		status, body := testRequest(t, ts, http.MethodPost, path+"/unlock", "password=secret", form, nil)
+		assert.Equal(t, http.StatusTooManyRequests, status)
+		assert.Contains(t, body, "Too many attempts")
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", map[string]string{"X-Link-Password": "secret"})
+		assert.Equal(t, http.StatusTooManyRequests, status)
+	})
+
+	t.Run("concurrent_attempts", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://internal.example/guarded","password":"secret"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var guarded models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &guarded))
+		unlock := ts.URL + strings.TrimPrefix(guarded.Result, config.ServerConfig.Base.String()) + "/unlock"
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		statuses := make(map[int]int)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := ts.Client().Post(unlock, form, strings.NewReader("password=wrong"))
+				if !assert.NoError(t, err) {
+					return
+				}
+				resp.Body.Close()
+				mu.Lock()
+				statuses[resp.StatusCode]++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, map[int]int{http.StatusUnauthorized: 2, http.StatusTooManyRequests: 8}, statuses)
+	})
+
+	t.Run("password_too_long", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten",
+			`{"url":"https://internal.example/long","password":"`+strings.Repeat("a", 73)+`"}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("already_shortened", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://internal.example/public"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var public models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &public))
+
+		status, body = testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://internal.example/public","password":"secret"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var protected models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &protected))
+		assert.NotEqual(t, public.Result, protected.Result)
+
+		status, _ = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(protected.Result, config.ServerConfig.Base.String()), "", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(public.Result, config.ServerConfig.Base.String()), "", "", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+	})
+}
+
+func TestMaxClicks(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://invite.example/team","max_clicks":2}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var created models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	path := strings.TrimPrefix(created.Result, config.ServerConfig.Base.String())
+
+	for i := 0; i < 2; i++ {
+		status, _ := testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+	}
+	status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+	assert.Equal(t, http.StatusGone, status)
+	status, _ = testRequest(t, ts, http.MethodGet, path+"+", "", "", nil)
+	assert.Equal(t, http.StatusGone, status)
+
+	t.Run("invalid", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://invite.example/invalid","max_clicks":-1}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("shortened_url", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://invite.example/shared"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var unlimited models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &unlimited))
+		status, body = testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://invite.example/shared","max_clicks":1}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var limited models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &limited))
+
+		path := strings.TrimPrefix(limited.Result, config.ServerConfig.Base.String())
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+		status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+		assert.Equal(t, http.StatusGone, status)
+		status, _ = testRequest(t, ts, http.MethodGet, strings.TrimPrefix(unlimited.Result, config.ServerConfig.Base.String()), "", "", nil)
+		assert.Equal(t, http.StatusTemporaryRedirect, status)
+	})
+}
+
+func TestUpdateURL(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	ts.Client().Jar = jar
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://release.example/v1"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var created models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	path := strings.TrimPrefix(created.Result, config.ServerConfig.Base.String())
+
+	status, body = testRequest(t, ts, http.MethodPatch, "/api/user/urls"+path, `{"url":"HTTPS://Release.example/v2"}`, "application/json", nil)
+	require.Equal(t, http.StatusOK, status)
+	var updated models.URLRecord
+	require.NoError(t, json.Unmarshal([]byte(body), &updated))
+	assert.Equal(t, models.URLRecord{ShortURL: created.Result, OriginalURL: "https://release.example/v2"}, updated)
+
+	status, _ = testRequest(t, ts, http.MethodGet, path, "", "", nil)
+	require.Equal(t, http.StatusTemporaryRedirect, status)
+
+	status, body = testRequest(t, ts, http.MethodGet, "/api/user/urls"+path+"/revisions", "", "", nil)
+	require.Equal(t, http.StatusOK, status)
+	var revisions []models.URLRevision
+	require.NoError(t, json.Unmarshal([]byte(body), &revisions))
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "https://release.example/v1", revisions[0].OldURL)
+	assert.Equal(t, "https://release.example/v2", revisions[0].NewURL)
+
+	t.Run("shorten_previous_url", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://release.example/v1"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+		var shortened models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &shortened))
+		assert.NotEqual(t, created.Result, shortened.Result)
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+strings.TrimPrefix(shortened.Result, config.ServerConfig.Base.String()), nil)
+		require.NoError(t, err)
+		resp, err := ts.Client().Transport.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "https://release.example/v1", resp.Header.Get("Location"))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/api/user/urls"+path+"/rollback", `{"revision":1}`, "application/json", nil)
+		require.Equal(t, http.StatusOK, status)
+		var rolledBack models.URLRecord
+		require.NoError(t, json.Unmarshal([]byte(body), &rolledBack))
+		assert.Equal(t, "https://release.example/v1", rolledBack.OriginalURL)
+
+		status, _ = testRequest(t, ts, http.MethodPost, "/api/user/urls"+path+"/rollback", `{"revision":10}`, "application/json", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("invalid_url", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPatch, "/api/user/urls"+path, `{"url":"javascript:alert(1)"}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("other_user", func(t *testing.T) {
+		jar, _ := cookiejar.New(nil)
+		ts.Client().Jar = jar
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://release.example/other"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+
+		// shortened url of other user is returned without taking it over
+		status, body := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://release.example/v1"}`, "application/json", nil)
+		require.Equal(t, http.StatusConflict, status)
+		var shortened models.Response
+		require.NoError(t, json.Unmarshal([]byte(body), &shortened))
+		assert.Equal(t, created.Result, shortened.Result)
+
+		status, _ = testRequest(t, ts, http.MethodPatch, "/api/user/urls"+path, `{"url":"https://attacker.example/"}`, "application/json", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls"+path+"/revisions", "", "", nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+}
+
+func TestUserURLNotFound(t *testing.T) {
+	for _, backend := range []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"memory", func(t *testing.T) {}},
+		{"file", func(t *testing.T) {
+			config.ServerConfig.FileStoragePath = filepath.Join(t.TempDir(), "short-url-db.json")
+		}},
+		{"bolt", func(t *testing.T) {
+			config.ServerConfig.BoltStoragePath = filepath.Join(t.TempDir(), "shortener.bolt")
+		}},
+		{"redis", func(t *testing.T) {
+			server, err := resptest.NewServer()
+			require.NoError(t, err)
+			t.Cleanup(func() { server.Close() })
+			config.ServerConfig.RedisAddress = server.Addr()
+		}},
+		{"database", func(t *testing.T) {
+			config.ServerConfig.DatabaseDSN = "sqlite://" + filepath.Join(t.TempDir(), "shortener.db")
+		}},
+	} {
+		t.Run(backend.name, func(t *testing.T) {
+			saved := config.ServerConfig
+			defer func() { config.ServerConfig = saved }()
+			config.ServerConfig.StorageBackend = backend.name
+			backend.setup(t)
+
+			server, err := NewServer(models.BuildInfo{})
+			require.NoError(t, err)
+			defer server.Close()
+
+			ts := httptest.NewServer(server.newRootRouter())
+			defer ts.Close()
+
+			jar, _ := cookiejar.New(nil)
+			ts.Client().Jar = jar
+			status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://missing.example/"}`, "application/json", nil)
+			require.Equal(t, http.StatusCreated, status)
+
+			for _, request := range []struct{ method, path, body string }{
+				{http.MethodPatch, "/api/user/urls/missing", `{"url":"https://missing.example/new"}`},
+				{http.MethodPatch, "/api/user/urls/missing", `{"title":"Missing"}`},
+				{http.MethodGet, "/api/user/urls/missing/revisions", ""},
+				{http.MethodPost, "/api/user/urls/missing/rollback", `{"revision":1}`},
+			} {
+				status, _ := testRequest(t, ts, request.method, request.path, request.body, "application/json", nil)
+				assert.Equal(t, http.StatusNotFound, status, request.method+" "+request.path+" "+request.body)
+			}
+		})
+	}
+}
+
+func TestURLMetadata(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	ts.Client().Jar = jar
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten",
+		`{"url":"https://docs.example/guide","title":" Guide ","tags":["Docs"," go ","docs"],"notes":"for onboarding"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var created models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	path := strings.TrimPrefix(created.Result, config.ServerConfig.Base.String())
+
+	status, _ = testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://docs.example/other"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+
+	status, body = testRequest(t, ts, http.MethodGet, "/api/user/urls?tag=DOCS", "", "", nil)
+	require.Equal(t, http.StatusOK, status)
+	var urls []models.URLRecord
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	assert.Equal(t, []models.URLRecord{{ShortURL: created.Result, OriginalURL: "https://docs.example/guide", Title: "Guide",
+		Tags: []string{"docs", "go"}, Notes: "for onboarding"}}, urls)
+
+	status, body = testRequest(t, ts, http.MethodPatch, "/api/user/urls"+path, `{"tags":["archive"]}`, "application/json", nil)
+	require.Equal(t, http.StatusOK, status)
+	var updated models.URLRecord
+	require.NoError(t, json.Unmarshal([]byte(body), &updated))
+	assert.Equal(t, models.URLRecord{ShortURL: created.Result, OriginalURL: "https://docs.example/guide", Title: "Guide",
+		Tags: []string{"archive"}, Notes: "for onboarding"}, updated)
+
+	status, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls?tag=docs", "", "", nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, body = testRequest(t, ts, http.MethodGet, "/api/user/urls", "", "", nil)
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	assert.Len(t, urls, 2)
+
+	t.Run("invalid", func(t *testing.T) {
+		status, _ := testRequest(t, ts, http.MethodPatch, "/api/user/urls"+path, `{"tags":["a,b"]}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = testRequest(t, ts, http.MethodPost, "/api/shorten",
+			`{"url":"https://docs.example/long","notes":"`+strings.Repeat("n", 2001)+`"}`, "application/json", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("concurrent_fields", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			for _, update := range []string{`{"title":"Handbook"}`, `{"notes":"for everyone"}`} {
+				wg.Add(1)
+				go func(update string) {
+					defer wg.Done()
+					req, err := http.NewRequest(http.MethodPatch, ts.URL+"/api/user/urls"+path, strings.NewReader(update))
+					if !assert.NoError(t, err) {
+						return
+					}
+					req.Header.Set("Content-Type", "application/json")
+					resp, err := ts.Client().Do(req)
+					if !assert.NoError(t, err) {
+						return
+					}
+					resp.Body.Close()
+					assert.Equal(t, http.StatusOK, resp.StatusCode)
+				}(update)
+			}
+		}
+		wg.Wait()
+
+		status, body := testRequest(t, ts, http.MethodGet, "/api/user/urls?tag=archive", "", "", nil)
+		require.Equal(t, http.StatusOK, status)
+		require.NoError(t, json.Unmarshal([]byte(body), &urls))
+		assert.Equal(t, []models.URLRecord{{ShortURL: created.Result, OriginalURL: "https://docs.example/guide", Title: "Handbook",
+			Tags: []string{"archive"}, Notes: "for everyone"}}, urls)
+	})
+
+	t.Run("shortened_by_other_user", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodPost, "/", "https://docs.example/other", "text/plain; charset=utf-8", nil)
+		require.Equal(t, http.StatusConflict, status)
+		other := strings.TrimPrefix(body, config.ServerConfig.Base.String())
+		status, _ = testRequest(t, ts, http.MethodPatch, "/api/user/urls"+other, `{"title":"Other","tags":["misc"],"notes":"kept"}`, "application/json", nil)
+		require.Equal(t, http.StatusOK, status)
+
+		ts.Client().Jar, _ = cookiejar.New(nil)
+		status, _ = testRequest(t, ts, http.MethodPost, "/", "https://docs.example/other", "text/plain; charset=utf-8", nil)
+		require.Equal(t, http.StatusConflict, status)
+		ts.Client().Jar = jar
+
+		status, body = testRequest(t, ts, http.MethodGet, "/api/user/urls?tag=misc", "", "", nil)
+		require.Equal(t, http.StatusOK, status)
+		require.NoError(t, json.Unmarshal([]byte(body), &urls))
+		assert.Equal(t, []models.URLRecord{{ShortURL: config.ServerConfig.Base.String() + other, OriginalURL: "https://docs.example/other",
+			Title: "Other", Tags: []string{"misc"}, Notes: "kept"}}, urls)
+	})
+}
+
+func TestGetURLSPagination(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	ts.Client().Jar = jar
+
+	for _, path := range []string{"first", "second", "third"} {
+		status, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://pages.example/`+path+`"}`, "application/json", nil)
+		require.Equal(t, http.StatusCreated, status)
+	}
+
+	getPage := func(query string) ([]models.URLRecord, string) {
+		resp, err := ts.Client().Get(ts.URL + "/api/user/urls?" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var urls []models.URLRecord
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+		return urls, resp.Header.Get("Link")
+	}
+
+	urls, link := getPage("order=asc&limit=2")
+	require.Len(t, urls, 2)
+	assert.Equal(t, "https://pages.example/first", urls[0].OriginalURL)
+	assert.Equal(t, "https://pages.example/second", urls[1].OriginalURL)
+	require.Regexp(t, `^<.*/api/user/urls\?.*cursor=.*>; rel="next"$`, link)
+
+	next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`))
+	require.NoError(t, err)
+	urls, link = getPage(next.RawQuery)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://pages.example/third", urls[0].OriginalURL)
+	assert.Empty(t, link)
+
+	urls, _ = getPage("search=SECOND")
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://pages.example/second", urls[0].OriginalURL)
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{"order=random", "limit=-1", "limit=many", "include_deleted=maybe", "cursor=broken"} {
+			status, _ := testRequest(t, ts, http.MethodGet, "/api/user/urls?"+query, "", "", nil)
+			assert.Equal(t, http.StatusBadRequest, status, query)
+		}
+	})
+}
+
+func TestExportURLS(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	ts.Client().Jar = jar
+
+	status, body := testRequest(t, ts, http.MethodPost, "/api/shorten",
+		`{"url":"https://export.example/first","title":"First","tags":["go","docs"],"notes":"a, \"quoted\" note"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var first models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &first))
+	status, body = testRequest(t, ts, http.MethodPost, "/api/shorten", `{"url":"https://export.example/second"}`, "application/json", nil)
+	require.Equal(t, http.StatusCreated, status)
+	var second models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &second))
+
+	t.Run("csv", func(t *testing.T) {
+		resp, err := ts.Client().Get(ts.URL + "/api/user/urls/export?order=asc")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="urls.csv"`, resp.Header.Get("Content-Disposition"))
+
+		rows, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"short_url", "original_url", "title", "tags", "notes", "deleted"},
+			{first.Result, "https://export.example/first", "First", "go,docs", `a, "quoted" note`, "false"},
+			{second.Result, "https://export.example/second", "", "", "", "false"},
+		}, rows)
+	})
+
+	t.Run("jsonl_gzip", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls/export?format=jsonl&tag=GO", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+		zr, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		decoder := json.NewDecoder(zr)
+		var url models.URLRecord
+		require.NoError(t, decoder.Decode(&url))
+		assert.Equal(t, models.URLRecord{ShortURL: first.Result, OriginalURL: "https://export.example/first",
+			Title: "First", Tags: []string{"go", "docs"}, Notes: `a, "quoted" note`}, url)
+		assert.ErrorIs(t, decoder.Decode(&url), io.EOF)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		status, body := testRequest(t, ts, http.MethodGet, "/api/user/urls/export?format=jsonl&search=missing", "", "", nil)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Empty(t, body)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{"format=xml", "order=random", "include_deleted=maybe"} {
+			status, _ := testRequest(t, ts, http.MethodGet, "/api/user/urls/export?"+query, "", "", nil)
+			assert.Equal(t, http.StatusBadRequest, status, query)
+		}
+	})
+}
+
+func TestImportURLS(t *testing.T) {
+	server, err := NewServer(models.BuildInfo{})
+	require.NoError(t, err)
+	defer server.Close()
+
+	ts := httptest.NewServer(server.newRootRouter())
+	defer ts.Close()
+
+	newUser := func() *http.Client {
+		client := &http.Client{CheckRedirect: func(_ *http.Request, _ []*http.Request) error { return http.ErrUseLastResponse }}
+		client.Jar, _ = cookiejar.New(nil)
+		resp, err := client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://import.example/user"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return client
+	}
+	importURLS := func(client *http.Client, contentType string, body string) []models.ImportResult {
+		resp, err := client.Post(ts.URL+"/api/user/urls/import", contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		var results []models.ImportResult
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			var result models.ImportResult
+			require.NoError(t, decoder.Decode(&result))
+			results = append(results, result)
+		}
+		return results
+	}
+	base := config.ServerConfig.Base.String()
+	owner := newUser()
+
+	t.Run("csv", func(t *testing.T) {
+		results := importURLS(owner, "text/csv", "short_url,original_url,title,tags,notes,deleted\n"+
+			"https://old.example/imported-1,https://import.example/1,Docs,\"go,docs\",,\n"+
+			",https://import.example/2,,,,\n"+
+			"bad alias!,https://import.example/3,,,,\n"+
+			"imported-1,https://import.example/4,,,,\n"+
+			"imported-gone,https://import.example/5,,,,true\n"+
+			"imported-6,not a url,,,,\n")
+		require.Len(t, results, 6)
+		assert.Equal(t, models.ImportResult{Row: 1, ShortURL: base + "/imported-1", Status: models.ImportCreated}, results[0])
+		assert.Equal(t, models.ImportCreated, results[1].Status)
+		assert.Equal(t, models.ImportFailed, results[2].Status)
+		assert.Contains(t, results[2].Error, "invalid alias")
+		assert.Equal(t, models.ImportResult{Row: 4, ShortURL: base + "/imported-1", Status: models.ImportConflict}, results[3])
+		assert.Equal(t, models.ImportResult{Row: 5, ShortURL: "imported-gone", Status: models.ImportSkipped}, results[4])
+		assert.Equal(t, models.ImportFailed, results[5].Status)
+
+		resp, err := owner.Get(ts.URL + "/imported-1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+		assert.Equal(t, "https://import.example/1", resp.Header.Get("Location"))
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		results := importURLS(owner, "application/x-ndjson", `{"short_url":"imported-7","original_url":"https://import.example/7","tags":["Go"]}`+"\n"+
+			"not json\n\n"+
+			`{"original_url":"https://import.example/8"}`+"\n")
+		require.Len(t, results, 3)
+		assert.Equal(t, models.ImportResult{Row: 1, ShortURL: base + "/imported-7", Status: models.ImportCreated}, results[0])
+		assert.Equal(t, 2, results[1].Row)
+		assert.Equal(t, models.ImportFailed, results[1].Status)
+		assert.Equal(t, 3, results[2].Row)
+		assert.Equal(t, models.ImportCreated, results[2].Status)
+
+		resp, err := owner.Get(ts.URL + "/api/user/urls?tag=go")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var urls []models.URLRecord
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&urls))
+		require.Len(t, urls, 2)
+		assert.Equal(t, base+"/imported-7", urls[0].ShortURL)
+		assert.Equal(t, []string{"go"}, urls[0].Tags)
+	})
+
+	t.Run("alias_of_other_user_is_kept", func(t *testing.T) {
+		results := importURLS(newUser(), "text/csv", "short_url,original_url\nimported-1,https://evil.example/\n")
+		assert.Equal(t, []models.ImportResult{{Row: 1, ShortURL: base + "/imported-1", Status: models.ImportConflict}}, results)
+
+		resp, err := owner.Get(ts.URL + "/imported-1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "https://import.example/1", resp.Header.Get("Location"))
+	})
+
+	t.Run("batch_with_existing_alias", func(t *testing.T) {
+		results := importURLS(owner, "text/csv", "short_url,original_url\n"+
+			"imported-9,https://import.example/9\n"+
+			"imported-7,https://import.example/7\n"+
+			"imported-10,https://import.example/10\n")
+		assert.Equal(t, []models.ImportResult{
+			{Row: 1, ShortURL: base + "/imported-9", Status: models.ImportCreated},
+			{Row: 2, ShortURL: base + "/imported-7", Status: models.ImportConflict},
+			{Row: 3, ShortURL: base + "/imported-10", Status: models.ImportCreated},
+		}, results)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for contentType, body := range map[string]string{
+			"application/xml": "<urls/>",
+			"text/csv":        "short_url,url\nabc,https://import.example/\n",
+		} {
+			resp, err := owner.Post(ts.URL+"/api/user/urls/import", contentType, strings.NewReader(body))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, contentType)
+		}
+	})
+}
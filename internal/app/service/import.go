@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInvalidAlias - imported short url is too long, reserved or contains characters not allowed in path
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// max length of imported short url, it fits shortURL column
+const maxAliasLength = 50
+
+// short urls used by routes of service
+var reservedAliases = map[string]bool{"api": true, "ping": true}
+
+// short url id of imported row, full short url of previous shortener is reduced to its last path segment
+func importAlias(shortURL string) (string, error) {
+	alias := shortURL[strings.LastIndex(shortURL, "/")+1:]
+	if alias == "" || len(alias) > maxAliasLength || reservedAliases[strings.ToLower(alias)] {
+		return "", fmt.Errorf("%w '%s'", ErrInvalidAlias, shortURL)
+	}
+	for _, c := range alias {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", fmt.Errorf("%w '%s'", ErrInvalidAlias, shortURL)
+		}
+	}
+	return alias, nil
+}
+
+// validate imported row like created url
+func (s *urlService) importRecord(url models.URLRecord, userID string, createdAt time.Time) (repository.URLRecord, error) {
+	urlString, err := s.normalizer.Normalize(url.OriginalURL)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	if err := s.policy.CheckURL(urlString); err != nil {
+		return repository.URLRecord{}, err
+	}
+	metadata := repository.URLMetadata{Title: url.Title, Tags: url.Tags, Notes: url.Notes}
+	if err := validateMetadata(&metadata); err != nil {
+		return repository.URLRecord{}, err
+	}
+	id := s.createShortURL([]byte(urlString))
+	if url.ShortURL != "" {
+		if id, err = importAlias(url.ShortURL); err != nil {
+			return repository.URLRecord{}, err
+		}
+	}
+	return repository.URLRecord{ID: id, URL: urlString, UserID: userID, CreatedAt: createdAt,
+		Title: metadata.Title, Tags: metadata.Tags, Notes: metadata.Notes}, nil
+}
+
+// import batch of user urls, given short urls are kept as aliases and missing ones are generated,
+// deleted urls are skipped; results are in order of urls, error means failure of whole batch
+func (s *urlService) ImportURLS(ctx context.Context, userID string, urls []models.URLRecord) (_ []models.ImportResult, err error) {
+	ctx, span := startSpan(ctx, "ImportURLS", attribute.Int("shortener.urls", len(urls)))
+	defer func() { tracing.End(span, err) }()
+
+	results := make([]models.ImportResult, len(urls))
+	var records []repository.URLRecord
+	var indexes []int // result index of record
+	now := time.Now().UTC()
+	for i, url := range urls {
+		if url.Deleted {
+			results[i] = models.ImportResult{ShortURL: url.ShortURL, Status: models.ImportSkipped}
+			continue
+		}
+		record, err := s.importRecord(url, userID, now)
+		if err != nil {
+			results[i] = models.ImportResult{ShortURL: url.ShortURL, Status: models.ImportFailed, Error: err.Error()}
+			continue
+		}
+		records = append(records, record)
+		indexes = append(indexes, i)
+	}
+	if len(records) == 0 {
+		return results, nil
+	}
+
+	createURLS := func(records []repository.URLRecord) error {
+		ctx, cancel := writeContext(ctx)
+		defer cancel()
+		return s.repository.CreateURLS(ctx, records)
+	}
+	rowErrors := make([]error, len(records)) // error of record, nil when it is created
+	err = createURLS(records)
+	if errors.Is(err, repository.ErrConflict) {
+		// batch with conflict is not created, conflicting rows are found by reads of their short urls
+		// and other rows are created again as one batch
+		var retry []repository.URLRecord
+		var retryIndexes []int
+		for j, conflict := range s.importConflicts(ctx, records) {
+			if conflict {
+				rowErrors[j] = repository.ErrConflict
+				continue
+			}
+			retry = append(retry, records[j])
+			retryIndexes = append(retryIndexes, j)
+		}
+		err = nil
+		if len(retry) > 0 {
+			err = createURLS(retry)
+		}
+		// short urls could be created after reads, then rows are created one by one
+		if errors.Is(err, repository.ErrConflict) {
+			for i, record := range retry {
+				rowErrors[retryIndexes[i]] = createURLS([]repository.URLRecord{record})
+			}
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var created []repository.URLRecord
+	for j, record := range records {
+		result := models.ImportResult{ShortURL: record.ID, Status: models.ImportCreated}
+		if errors.Is(rowErrors[j], repository.ErrConflict) {
+			result.Status = models.ImportConflict
+		} else if rowErrors[j] != nil {
+			result.Status, result.Error = models.ImportFailed, rowErrors[j].Error()
+		}
+		if result.Status == models.ImportCreated {
+			created = append(created, record)
+		}
+		results[indexes[j]] = result
+	}
+	s.checkReputationAsync(ctx, created)
+	return results, nil
+}
+
+// rows of batch whose short url exists or is taken by previous row of batch, deleted urls keep their
+// short urls; rows whose short url can't be read are created again and fail with the batch
+func (s *urlService) importConflicts(ctx context.Context, records []repository.URLRecord) []bool {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	conflicts := make([]bool, len(records))
+	seen := make(map[string]bool, len(records))
+	for j, record := range records {
+		if seen[record.ID] {
+			conflicts[j] = true
+			continue
+		}
+		seen[record.ID] = true
+		_, err := s.repository.GetURL(ctx, record.ID)
+		conflicts[j] = err == nil || errors.Is(err, repository.ErrURLDeleted)
+	}
+	return conflicts
+}
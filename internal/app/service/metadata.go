@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInvalidTag - tag is empty, too long or contains comma
+var ErrInvalidTag = errors.New("invalid tag")
+
+// ErrTooManyTags - url has more than maxTags tags
+var ErrTooManyTags = errors.New("too many tags")
+
+// ErrNotesTooLong - notes of url are longer than maxNotesLength
+var ErrNotesTooLong = errors.New("notes are too long")
+
+// limits of url metadata in characters
+const (
+	maxTags        = 20
+	maxTagLength   = 50
+	maxNotesLength = 2000
+)
+
+// normalize tag to lowercase without surrounding spaces
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalize tags and drop duplicates, order of tags is kept
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || strings.Contains(tag, ",") || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("%w '%s'", ErrInvalidTag, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	if len(result) > maxTags {
+		return nil, ErrTooManyTags
+	}
+	return result, nil
+}
+
+// check and normalize title, tags and notes
+func validateMetadata(metadata *repository.URLMetadata) error {
+	metadata.Title = strings.TrimSpace(metadata.Title)
+	if utf8.RuneCountInString(metadata.Title) > maxTitleLength {
+		return ErrTitleTooLong
+	}
+	tags, err := normalizeTags(metadata.Tags)
+	if err != nil {
+		return err
+	}
+	metadata.Tags = tags
+	if utf8.RuneCountInString(metadata.Notes) > maxNotesLength {
+		return ErrNotesTooLong
+	}
+	return nil
+}
+
+// change title, tags and notes of user url, missing fields of update are kept;
+// fields are validated one by one and merged by repository, so concurrent updates of other fields are kept
+func (s *urlService) UpdateMetadata(ctx context.Context, id string, userID string, update models.UpdateRequest) (_ models.URLRecord, err error) {
+	ctx, span := startSpan(ctx, "UpdateMetadata", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	var metadata repository.URLMetadata
+	if update.Title != nil {
+		metadata.Title = *update.Title
+	}
+	if update.Tags != nil {
+		metadata.Tags = *update.Tags
+	}
+	if update.Notes != nil {
+		metadata.Notes = *update.Notes
+	}
+	if err := validateMetadata(&metadata); err != nil {
+		return models.URLRecord{}, err
+	}
+	var changes repository.MetadataUpdate
+	if update.Title != nil {
+		changes.Title = &metadata.Title
+	}
+	if update.Tags != nil {
+		changes.Tags = &metadata.Tags
+	}
+	if update.Notes != nil {
+		changes.Notes = &metadata.Notes
+	}
+
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	record, err := s.repository.UpdateMetadata(ctx, id, userID, changes)
+	if err != nil {
+		return models.URLRecord{}, err
+	}
+	return models.URLRecord{ShortURL: id, OriginalURL: record.URL, Title: record.Title, Tags: record.Tags, Notes: record.Notes}, nil
+}
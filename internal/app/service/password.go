@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordRequired - url is protected and password is not provided
+var ErrPasswordRequired = errors.New("password required")
+
+// ErrInvalidPassword - provided password doesn't match
+var ErrInvalidPassword = errors.New("invalid password")
+
+// ErrTooManyAttempts - url is locked after failed password attempts
+var ErrTooManyAttempts = errors.New("too many password attempts")
+
+// ErrPasswordTooLong - password is longer than bcrypt supports
+var ErrPasswordTooLong = errors.New("password is too long")
+
+// bcrypt uses only first 72 bytes of password
+const maxPasswordLength = 72
+
+// throttled urls are cleaned up when there are more of them
+const maxThrottledURLS = 10000
+
+// hash password of created url, empty password keeps url public
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > maxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// password attempts of url in current window
+type passwordAttempts struct {
+	count int
+	start time.Time
+}
+
+// password attempts are counted per url in window of lockout time before password is checked,
+// url is locked until end of window after max attempts, successful attempt resets them
+type passwordThrottle struct {
+	maxAttempts int
+	lockout     time.Duration
+	mu          sync.Mutex
+	attempts    map[string]passwordAttempts
+}
+
+func newPasswordThrottle(maxAttempts int, lockout time.Duration) *passwordThrottle {
+	return &passwordThrottle{maxAttempts: maxAttempts, lockout: lockout, attempts: make(map[string]passwordAttempts)}
+}
+
+// count attempt before password is checked, so concurrent attempts can't exceed max attempts;
+// false when url is locked, expired windows are dropped when there are too many urls
+func (t *passwordThrottle) reserve(id string, now time.Time) bool {
+	if t.maxAttempts <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.attempts[id]
+	if !ok || now.Sub(a.start) >= t.lockout {
+		if len(t.attempts) >= maxThrottledURLS {
+			for key, attempts := range t.attempts {
+				if now.Sub(attempts.start) >= t.lockout {
+					delete(t.attempts, key)
+				}
+			}
+		}
+		a = passwordAttempts{start: now}
+	}
+	if a.count >= t.maxAttempts {
+		return false
+	}
+	a.count++
+	t.attempts[id] = a
+	return true
+}
+
+// forget attempts after successful one, including its own reserved attempt
+func (t *passwordThrottle) reset(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.attempts, id)
+}
+
+// get protected url record by password, redirect is counted after successful check
+func (s *urlService) UnlockURL(ctx context.Context, id string, password string) (_ repository.URLRecord, err error) {
+	ctx, span := startSpan(ctx, "UnlockURL", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	record, err := s.PreviewURL(ctx, id)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	if record.PasswordHash != "" {
+		if password == "" {
+			return repository.URLRecord{}, ErrPasswordRequired
+		}
+		if !s.passwords.reserve(id, time.Now()) {
+			logger.FromContext(ctx).Warn("protected url is locked", zap.String("id", id))
+			return repository.URLRecord{}, ErrTooManyAttempts
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)); err != nil {
+			return repository.URLRecord{}, ErrInvalidPassword
+		}
+		s.passwords.reset(id)
+	}
+	if record.Threat == "" {
+		if err := s.claimClick(ctx, record); err != nil {
+			return repository.URLRecord{}, err
+		}
+		s.redirects.add(id)
+	}
+	return record, nil
+}
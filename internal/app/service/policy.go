@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/policy"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"go.uber.org/zap"
+)
+
+// rules of domain policy
+func (s *urlService) DomainRules() []policy.Rule {
+	return s.policy.Rules()
+}
+
+// add rule to domain policy
+func (s *urlService) AddDomainRule(rule policy.Rule) error {
+	return s.policy.Add(rule)
+}
+
+// remove rule from domain policy
+func (s *urlService) RemoveDomainRule(rule policy.Rule) error {
+	return s.policy.Remove(rule)
+}
+
+// delete stored urls with domains which are not permitted by policy, returns number of deleted urls
+func (s *urlService) DisableBlockedURLS(ctx context.Context) (_ int, err error) {
+	ctx, span := startSpan(ctx, "DisableBlockedURLS")
+	defer func() { tracing.End(span, err) }()
+
+	readCtx, cancel := readContext(ctx)
+	domains, err := s.repository.GetDomains(readCtx)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	var blocked []string
+	for _, domain := range domains {
+		if err := s.policy.Check(domain); errors.Is(err, policy.ErrDomainBlocked) {
+			blocked = append(blocked, domain)
+		}
+	}
+	if len(blocked) == 0 {
+		return 0, nil
+	}
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	deleted, err := s.repository.DeleteURLSByDomains(writeCtx, blocked)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to disable urls of blocked domains", zap.String("error", err.Error()))
+		return 0, err
+	}
+	logger.FromContext(ctx).Info("urls of blocked domains are disabled",
+		zap.Strings("domains", blocked),
+		zap.Int("urls", len(deleted)))
+	return len(deleted), nil
+}
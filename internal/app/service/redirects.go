@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"go.uber.org/zap"
+)
+
+// redirect counts are kept in memory and flushed to repository in batches,
+// so redirect doesn't cost a storage write; redirects are counted by UTC day
+type redirectCounter struct {
+	mu     sync.Mutex
+	counts map[repository.RedirectKey]int64
+}
+
+func (c *redirectCounter) add(id string) {
+	key := repository.RedirectKey{ID: id, Day: time.Now().UTC().Format(models.DayLayout)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[repository.RedirectKey]int64)
+	}
+	c.counts[key]++
+}
+
+// take counts collected since previous take
+func (c *redirectCounter) take() map[repository.RedirectKey]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = nil
+	return counts
+}
+
+// return counts which were not flushed
+func (c *redirectCounter) merge(counts map[repository.RedirectKey]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[repository.RedirectKey]int64)
+	}
+	for key, count := range counts {
+		c.counts[key] += count
+	}
+}
+
+// flush collected redirects to repository, counts are kept for next flush on error
+func (s *urlService) flushRedirects(ctx context.Context) error {
+	counts := s.redirects.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	err := s.repository.AddRedirects(ctx, counts)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to flush redirects", zap.String("error", err.Error()))
+		s.redirects.merge(counts)
+	}
+	return err
+}
+
+// flush redirects periodically until service is closed
+func (s *urlService) flushRedirectsLoop(interval time.Duration) {
+	defer s.loops.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flushRedirects(context.Background())
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/config"
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/reputation"
+	"go.uber.org/zap"
+)
+
+// number of urls sent to reputation provider in one check
+const reputationBatchSize = 100
+
+var errUnknownReputationProvider = errors.New("unknown reputation provider")
+
+// ReputationChecker - provider of destination url reputation, returns threat types of unsafe urls
+type ReputationChecker interface {
+	Check(ctx context.Context, urls []string) (map[string]string, error)
+}
+
+// create reputation checker from config, nil checker disables reputation checks
+func newReputationChecker() (ReputationChecker, error) {
+	switch config.ServerConfig.ReputationProvider {
+	case "", "none":
+		return nil, nil
+	case "http":
+		return reputation.NewHTTPChecker(config.ServerConfig.ReputationEndpoint,
+			&http.Client{Timeout: time.Duration(config.ServerConfig.ReputationTimeout)}), nil
+	case "hashlist":
+		checker, err := reputation.NewHashPrefixChecker(config.ServerConfig.ReputationHashFile)
+		if err != nil {
+			return nil, err
+		}
+		return checker, nil
+	default:
+		return nil, errUnknownReputationProvider
+	}
+}
+
+// check reputation of urls and store threats which differ from stored ones
+func (s *urlService) checkReputation(ctx context.Context, records []repository.URLRecord) error {
+	urls := make([]string, 0, len(records))
+	for _, record := range records {
+		urls = append(urls, record.URL)
+	}
+	checkCtx, cancel := withTimeout(ctx, config.ServerConfig.ReputationTimeout)
+	threats, err := s.reputation.Check(checkCtx, urls)
+	cancel()
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to check url reputation", zap.String("error", err.Error()))
+		return err
+	}
+
+	changed := make(map[string]repository.Threat)
+	for _, record := range records {
+		threat := threats[record.URL]
+		if threat == record.Threat {
+			continue
+		}
+		changed[record.ID] = repository.Threat{URL: record.URL, Type: threat}
+		if threat != "" {
+			logger.FromContext(ctx).Warn("url is flagged by reputation provider",
+				zap.String("id", record.ID),
+				zap.String("url", record.URL),
+				zap.String("threat", threat))
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	writeCtx, cancel := writeContext(ctx)
+	defer cancel()
+	err = s.repository.SetThreats(writeCtx, changed)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to store url threats", zap.String("error", err.Error()))
+	}
+	return err
+}
+
+// check reputation of created urls in background, check is not cancelled with request context
+func (s *urlService) checkReputationAsync(ctx context.Context, records []repository.URLRecord) {
+	if s.reputation == nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for start := 0; start < len(records); start += reputationBatchSize {
+			end := min(start+reputationBatchSize, len(records))
+			if s.checkReputation(context.WithoutCancel(ctx), records[start:end]) != nil {
+				return
+			}
+		}
+	}()
+}
+
+// check reputation of all stored urls in batches
+func (s *urlService) recheckReputation(ctx context.Context) error {
+	batch := make([]repository.URLRecord, 0, reputationBatchSize)
+	err := s.repository.ScanURLS(ctx, func(record repository.URLRecord) error {
+		batch = append(batch, record)
+		if len(batch) < reputationBatchSize {
+			return nil
+		}
+		err := s.checkReputation(ctx, batch)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = s.checkReputation(ctx, batch)
+	}
+	return err
+}
+
+// recheck reputation of stored urls periodically until service is closed
+func (s *urlService) reputationLoop(interval time.Duration) {
+	defer s.loops.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-s.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if err := s.recheckReputation(ctx); err != nil {
+				logger.Log.Error("failed to recheck url reputation", zap.String("error", err.Error()))
+			}
+			cancel()
+		case <-s.stop:
+			return
+		}
+	}
+}
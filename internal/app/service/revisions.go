@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// ErrRevisionNotFound - rolled back revision doesn't exist
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// change destination of user url, new destination is validated like created urls;
+// returns normalized destination
+func (s *urlService) UpdateURL(ctx context.Context, id string, userID string, url string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "UpdateURL", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	urlString, err := s.normalizer.Normalize(url)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to validate url",
+			zap.String("url", url),
+			zap.String("error", err.Error()))
+		return "", err
+	}
+	if err := s.policy.CheckURL(urlString); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	if err := s.repository.UpdateURL(ctx, id, userID, urlString); err != nil {
+		return "", err
+	}
+
+	// threat of previous destination is cleared, new one is checked again
+	s.checkReputationAsync(ctx, []repository.URLRecord{{ID: id, URL: urlString, UserID: userID}})
+	return urlString, nil
+}
+
+// get revisions of user url
+func (s *urlService) GetRevisions(ctx context.Context, id string, userID string) (_ []models.URLRevision, err error) {
+	ctx, span := startSpan(ctx, "GetRevisions", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	record, err := s.repository.GetURL(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.UserID != userID {
+		return nil, repository.ErrNotOwner
+	}
+	return s.repository.GetRevisions(ctx, id)
+}
+
+// restore destination of user url before revision, rollback is recorded as new revision
+func (s *urlService) RollbackURL(ctx context.Context, id string, userID string, revision int) (_ string, err error) {
+	ctx, span := startSpan(ctx, "RollbackURL", attribute.String("shortener.id", id), attribute.Int("shortener.revision", revision))
+	defer func() { tracing.End(span, err) }()
+
+	revisions, err := s.GetRevisions(ctx, id, userID)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range revisions {
+		if r.Revision == revision {
+			return s.UpdateURL(ctx, id, userID, r.OldURL)
+		}
+	}
+	return "", ErrRevisionNotFound
+}
@@ -1,6 +1,13 @@
 package service
 
-import "github.com/rutkin/url-shortener/internal/app/models"
+import (
+	"context"
+	"time"
+
+	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/policy"
+	"github.com/rutkin/url-shortener/internal/app/repository"
+)
 
 type contextKey string
 
@@ -9,12 +16,26 @@ const UserIDKey contextKey = "userID"
 
 // service interface that implement logic
 type Service interface {
-	CreateURLS(urls []string, userID string) ([]string, error)
-	CreateURL(url []byte, userID string) (string, error)
-	GetURL(id string) (string, error)
-	GetURLS(userID string) ([]models.URLRecord, error)
-	DeleteURLS(urls []string, userID string) error
-	GetStats() (models.StatRecord, error)
-	PingDB() error
+	CreateURLS(ctx context.Context, urls []string, userID string) ([]string, error)
+	CreateURL(ctx context.Context, url []byte, userID string, options models.URLOptions) (string, error)
+	GetURL(ctx context.Context, id string) (repository.URLRecord, error)
+	PreviewURL(ctx context.Context, id string) (repository.URLRecord, error)
+	UnlockURL(ctx context.Context, id string, password string) (repository.URLRecord, error)
+	GetURLS(ctx context.Context, userID string, filter models.URLFilter) (models.URLPage, error)
+	ExportURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) error
+	ImportURLS(ctx context.Context, userID string, urls []models.URLRecord) ([]models.ImportResult, error)
+	UpdateURL(ctx context.Context, id string, userID string, url string) (string, error)
+	GetRevisions(ctx context.Context, id string, userID string) ([]models.URLRevision, error)
+	RollbackURL(ctx context.Context, id string, userID string, revision int) (string, error)
+	UpdateMetadata(ctx context.Context, id string, userID string, update models.UpdateRequest) (models.URLRecord, error)
+	DeleteURLS(ctx context.Context, urls []string, userID string) error
+	GetStats(ctx context.Context) (models.StatRecord, error)
+	GetTimeSeries(ctx context.Context, from, to time.Time) ([]models.TimeSeriesPoint, error)
+	DomainRules() []policy.Rule
+	AddDomainRule(rule policy.Rule) error
+	RemoveDomainRule(rule policy.Rule) error
+	DisableBlockedURLS(ctx context.Context) (int, error)
+	Health(ctx context.Context) models.HealthReport
+	Ready(ctx context.Context) error
 	Close() error
 }
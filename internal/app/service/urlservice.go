@@ -1,132 +1,522 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
 	"hash/crc32"
-	"net/url"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rutkin/url-shortener/internal/app/config"
 	"github.com/rutkin/url-shortener/internal/app/logger"
+	"github.com/rutkin/url-shortener/internal/app/metrics"
 	"github.com/rutkin/url-shortener/internal/app/models"
+	"github.com/rutkin/url-shortener/internal/app/policy"
 	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/rutkin/url-shortener/internal/app/tracing"
+	"github.com/rutkin/url-shortener/internal/app/urlnorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+)
+
+// ErrTitleTooLong - title of url is longer than maxTitleLength
+var ErrTitleTooLong = errors.New("title is too long")
+
+// ErrInvalidRedirectType - redirect type is not redirect status code
+var ErrInvalidRedirectType = errors.New("invalid redirect type")
+
+// ErrInvalidMaxClicks - max clicks is negative
+var ErrInvalidMaxClicks = errors.New("invalid max clicks")
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+// ErrInvalidPage - order or limit of user urls page is invalid
+var ErrInvalidPage = errors.New("invalid page")
+
+// errNoFreeShortURL - every random short url tried for new url exists
+var errNoFreeShortURL = errors.New("no free short url")
+
+// max length of url title in characters
+const maxTitleLength = 255
+
+// random short urls tried for url with options
+const (
+	randomShortURLBytes    = 6
+	randomShortURLAttempts = 3
 )
 
+// size of user urls page
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// check and normalize options of created url
+func validateOptions(options *models.URLOptions) error {
+	metadata := repository.URLMetadata{Title: options.Title, Tags: options.Tags, Notes: options.Notes}
+	if err := validateMetadata(&metadata); err != nil {
+		return err
+	}
+	options.Title, options.Tags, options.Notes = metadata.Title, metadata.Tags, metadata.Notes
+	switch options.RedirectType {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("%w %d", ErrInvalidRedirectType, options.RedirectType)
+	}
+	if options.MaxClicks < 0 {
+		return fmt.Errorf("%w %d", ErrInvalidMaxClicks, options.MaxClicks)
+	}
+	return nil
+}
+
 // create new instance of url service
 func NewURLService() (*urlService, error) {
+	domainPolicy, err := policy.NewDomainPolicy(config.ServerConfig.DomainPolicyFile,
+		time.Duration(config.ServerConfig.DomainPolicyReloadInterval))
+	if err != nil {
+		logger.Log.Error("failed to load domain policy", zap.String("error", err.Error()))
+		return nil, err
+	}
+
 	var db *sql.DB
 	if len(config.ServerConfig.DatabaseDSN) > 0 {
-		newDB, err := sql.Open("pgx", config.ServerConfig.DatabaseDSN)
+		newDB, err := repository.OpenDB(config.ServerConfig.DatabaseDSN)
 		if err != nil {
+			domainPolicy.Close()
 			return nil, err
 		}
 		db = newDB
 	}
 
+	checker, err := newReputationChecker()
+	if err != nil {
+		logger.Log.Error("failed to create reputation checker", zap.String("error", err.Error()))
+		domainPolicy.Close()
+		return nil, err
+	}
+
 	r, err := repository.NewRepository(db)
 	if err != nil {
+		domainPolicy.Close()
 		return nil, err
 	}
-	return &urlService{db, r, sync.WaitGroup{}}, nil
+
+	unregisterDBStats := func() {}
+	if db != nil {
+		unregisterDBStats = metrics.RegisterDBStats(db, "shortener")
+	}
+	unregisterCacheStats := func() {}
+	if cache, ok := r.(interface{ Stats() repository.CacheStats }); ok {
+		unregisterCacheStats = metrics.RegisterCacheStats(
+			func() uint64 { return cache.Stats().Hits },
+			func() uint64 { return cache.Stats().Misses })
+	}
+	s := &urlService{
+		db:                   db,
+		repository:           r,
+		backend:              repository.BackendName(db),
+		unregisterDBStats:    unregisterDBStats,
+		unregisterCacheStats: unregisterCacheStats,
+		normalizer: urlnorm.NewNormalizer(urlnorm.Options{
+			AllowedSchemes: strings.Split(config.ServerConfig.AllowedSchemes, ","),
+			MaxLength:      config.ServerConfig.MaxURLLength,
+			TrackingParams: strings.Split(config.ServerConfig.TrackingParams, ","),
+		}),
+		policy:     domainPolicy,
+		reputation: checker,
+		passwords: newPasswordThrottle(config.ServerConfig.PasswordMaxAttempts,
+			time.Duration(config.ServerConfig.PasswordLockout)),
+		stop: make(chan struct{}),
+	}
+	if interval := time.Duration(config.ServerConfig.RedirectFlushInterval); interval > 0 {
+		s.loops.Add(1)
+		go s.flushRedirectsLoop(interval)
+	}
+	if interval := time.Duration(config.ServerConfig.ReputationInterval); checker != nil && interval > 0 {
+		s.loops.Add(1)
+		go s.reputationLoop(interval)
+	}
+	return s, nil
 }
 
 type urlService struct {
-	db         *sql.DB
-	repository repository.Repository
-	wg         sync.WaitGroup
+	db                   *sql.DB
+	repository           repository.Repository
+	backend              string
+	wg                   sync.WaitGroup
+	unregisterDBStats    func()
+	unregisterCacheStats func()
+	normalizer           *urlnorm.Normalizer
+	policy               *policy.DomainPolicy
+	reputation           ReputationChecker
+	passwords            *passwordThrottle
+	redirects            redirectCounter
+	// background loops are stopped by close of stop channel
+	stop  chan struct{}
+	loops sync.WaitGroup
 }
 
 func (s *urlService) createShortURL(url []byte) string {
 	return fmt.Sprintf("%X", crc32.ChecksumIEEE(url))
 }
 
-func (s *urlService) deleteURLSAsync(urls []string, userID string) {
+// url with options gets its own random short url, so options of existing url with the same destination are kept
+func hasOptions(options models.URLOptions) bool {
+	return options.Title != "" || options.Preview || options.RedirectType != 0 || options.Password != "" ||
+		options.MaxClicks != 0 || len(options.Tags) > 0 || options.Notes != ""
+}
+
+// store url with short url of its destination, ErrConflict when the destination is already shortened;
+// stored url is checked first because short url could be retargeted or deleted by its owner,
+// then url gets random short url like url with options
+func (s *urlService) createPlainURL(ctx context.Context, record *repository.URLRecord) error {
+	record.ID = s.createShortURL([]byte(record.URL))
+	existing, err := s.repository.GetURL(ctx, record.ID)
+	if errors.Is(err, repository.ErrURLDeleted) || err == nil && existing.URL != record.URL {
+		return s.createRandomURL(ctx, record)
+	}
+	return s.repository.CreateURL(ctx, *record)
+}
+
+// store url with random short url, short url is chosen again when it exists
+func (s *urlService) createRandomURL(ctx context.Context, record *repository.URLRecord) error {
+	id := make([]byte, randomShortURLBytes)
+	for i := 0; i < randomShortURLAttempts; i++ {
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		record.ID = fmt.Sprintf("%X", id)
+		err := s.repository.CreateURL(ctx, *record)
+		if !errors.Is(err, repository.ErrConflict) {
+			return err
+		}
+	}
+	return errNoFreeShortURL
+}
+
+// storage context for read operations, limited by configured timeout
+func readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, config.ServerConfig.StorageReadTimeout)
+}
+
+// storage context for write operations, limited by configured timeout
+func writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, config.ServerConfig.StorageWriteTimeout)
+}
+
+// start span of service operation
+func startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "urlService."+operation, trace.WithAttributes(attrs...))
+}
+
+func withTimeout(ctx context.Context, timeout config.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout))
+}
+
+func (s *urlService) deleteURLSAsync(ctx context.Context, urls []string, userID string) {
 	defer s.wg.Done()
-	s.repository.DeleteURLS(urls, userID)
+	defer metrics.PendingDeletions.Dec()
+	ctx, span := startSpan(ctx, "deleteURLSAsync", attribute.Int("shortener.urls", len(urls)))
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	err := s.repository.DeleteURLS(ctx, urls, userID)
+	tracing.End(span, err)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to delete urls", zap.String("error", err.Error()))
+	}
 }
 
 // create urls
-func (s *urlService) CreateURLS(urls []string, userID string) ([]string, error) {
+func (s *urlService) CreateURLS(ctx context.Context, urls []string, userID string) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "CreateURLS", attribute.Int("shortener.urls", len(urls)))
+	defer func() { tracing.End(span, err) }()
+
 	var repositoryURLS []repository.URLRecord
 	var shortURLS []string
-	for _, url := range urls {
+	now := time.Now().UTC()
+	for i, url := range urls {
+		url, err := s.normalizer.Normalize(url)
+		if err != nil {
+			logger.FromContext(ctx).Error("failed to validate url",
+				zap.Int("index", i),
+				zap.String("error", err.Error()))
+			return nil, fmt.Errorf("url %d: %w", i, err)
+		}
+		if err := s.policy.CheckURL(url); err != nil {
+			return nil, fmt.Errorf("url %d: %w", i, err)
+		}
 		shortURL := s.createShortURL([]byte(url))
 		shortURLS = append(shortURLS, shortURL)
-		repositoryURLS = append(repositoryURLS, repository.URLRecord{ID: shortURL, URL: url, UserID: userID})
+		repositoryURLS = append(repositoryURLS, repository.URLRecord{ID: shortURL, URL: url, UserID: userID, CreatedAt: now})
 	}
 
-	err := s.repository.CreateURLS(repositoryURLS)
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	err = s.repository.CreateURLS(ctx, repositoryURLS)
 	if err != nil {
-		logger.Log.Error("failed to create urls", zap.String("error", err.Error()))
+		logger.FromContext(ctx).Error("failed to create urls", zap.String("error", err.Error()))
 		return nil, err
 	}
+	s.checkReputationAsync(ctx, repositoryURLS)
 	return shortURLS, nil
 }
 
-// create url
-func (s *urlService) CreateURL(urlBytes []byte, userID string) (string, error) {
-	urlString := string(urlBytes)
-
-	_, err := url.ParseRequestURI(urlString)
+// create url with options, url without options shares short url with the same destination
+func (s *urlService) CreateURL(ctx context.Context, urlBytes []byte, userID string, options models.URLOptions) (_ string, err error) {
+	ctx, span := startSpan(ctx, "CreateURL")
+	defer func() {
+		if errors.Is(err, repository.ErrConflict) {
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
 
+	urlString, err := s.normalizer.Normalize(string(urlBytes))
 	if err != nil {
-		logger.Log.Error("failed to parse url",
-			zap.String("url", urlString),
+		logger.FromContext(ctx).Error("failed to validate url",
+			zap.String("url", string(urlBytes)),
 			zap.String("error", err.Error()))
 		return "", err
 	}
+	if err := s.policy.CheckURL(urlString); err != nil {
+		return "", err
+	}
+	if err := validateOptions(&options); err != nil {
+		return "", err
+	}
+
+	passwordHash, err := hashPassword(options.Password)
+	if err != nil {
+		return "", err
+	}
 
-	id := fmt.Sprintf("%X", crc32.ChecksumIEEE(urlBytes))
-	err = s.repository.CreateURL(repository.URLRecord{ID: id, URL: urlString, UserID: userID})
+	record := repository.URLRecord{URL: urlString, UserID: userID, CreatedAt: time.Now().UTC(),
+		Title: options.Title, Preview: options.Preview, RedirectType: options.RedirectType, PasswordHash: passwordHash,
+		MaxClicks: options.MaxClicks, Tags: options.Tags, Notes: options.Notes}
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	if hasOptions(options) {
+		err = s.createRandomURL(ctx, &record)
+	} else {
+		err = s.createPlainURL(ctx, &record)
+	}
 
 	if errors.Is(err, repository.ErrConflict) {
-		return id, err
+		return record.ID, err
 	}
 	if err != nil {
-		logger.Log.Error("failed to create url",
+		logger.FromContext(ctx).Error("failed to create url",
 			zap.String("url", urlString),
 			zap.String("error", err.Error()))
 		return "", err
 	}
 
-	return id, nil
+	s.checkReputationAsync(ctx, []repository.URLRecord{record})
+	return record.ID, nil
 }
 
-// get url
-func (s *urlService) GetURL(id string) (string, error) {
-	return s.repository.GetURL(id)
+// get url record, redirect is counted and click is used only for url without threat and password,
+// redirect of protected url is counted by UnlockURL
+func (s *urlService) GetURL(ctx context.Context, id string) (_ repository.URLRecord, err error) {
+	ctx, span := startSpan(ctx, "GetURL", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	readCtx, cancel := readContext(ctx)
+	defer cancel()
+	record, err := s.repository.GetURL(readCtx, id)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	// domain could be blocked after url was created
+	if err := s.policy.CheckURL(record.URL); err != nil {
+		return repository.URLRecord{}, err
+	}
+	if record.Threat == "" && record.PasswordHash == "" {
+		if err := s.claimClick(ctx, record); err != nil {
+			return repository.URLRecord{}, err
+		}
+		s.redirects.add(id)
+	}
+	return record, nil
 }
 
-// get urls
-func (s *urlService) GetURLS(userID string) ([]models.URLRecord, error) {
-	return s.repository.GetURLS(userID)
+// use click of url with max clicks, exhausted url is not claimed again
+func (s *urlService) claimClick(ctx context.Context, record repository.URLRecord) error {
+	if record.MaxClicks == 0 {
+		return nil
+	}
+	if record.Clicks >= record.MaxClicks {
+		return repository.ErrURLExhausted
+	}
+	ctx, cancel := writeContext(ctx)
+	defer cancel()
+	return s.repository.ClaimClick(ctx, record.ID)
+}
+
+// get url record for preview, redirect is not counted
+func (s *urlService) PreviewURL(ctx context.Context, id string) (_ repository.URLRecord, err error) {
+	ctx, span := startSpan(ctx, "PreviewURL", attribute.String("shortener.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	record, err := s.repository.GetURL(ctx, id)
+	if err != nil {
+		return repository.URLRecord{}, err
+	}
+	if err := s.policy.CheckURL(record.URL); err != nil {
+		return repository.URLRecord{}, err
+	}
+	if record.MaxClicks > 0 && record.Clicks >= record.MaxClicks {
+		return repository.URLRecord{}, repository.ErrURLExhausted
+	}
+	return record, nil
+}
+
+// get page of user urls, newest urls are first by default
+func (s *urlService) GetURLS(ctx context.Context, userID string, filter models.URLFilter) (_ models.URLPage, err error) {
+	ctx, span := startSpan(ctx, "GetURLS")
+	defer func() { tracing.End(span, err) }()
+
+	if err := normalizeFilter(&filter); err != nil {
+		return models.URLPage{}, err
+	}
+	if filter.Limit < 0 {
+		return models.URLPage{}, fmt.Errorf("%w: negative limit %d", ErrInvalidPage, filter.Limit)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultPageSize
+	}
+	filter.Limit = min(filter.Limit, maxPageSize)
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	return s.repository.GetURLS(ctx, userID, filter)
+}
+
+// check order of user urls filter and normalize its tag
+func normalizeFilter(filter *models.URLFilter) error {
+	switch filter.Order {
+	case "":
+		filter.Order = models.OrderDesc
+	case models.OrderAsc, models.OrderDesc:
+	default:
+		return fmt.Errorf("%w: unknown order '%s'", ErrInvalidPage, filter.Order)
+	}
+	filter.Tag = normalizeTag(filter.Tag)
+	return nil
+}
+
+// call fn for all user urls matching filter, cursor and limit are ignored;
+// export is not limited by read timeout, it ends with request context
+func (s *urlService) ExportURLS(ctx context.Context, userID string, filter models.URLFilter, fn func(models.URLRecord) error) (err error) {
+	ctx, span := startSpan(ctx, "ExportURLS")
+	defer func() { tracing.End(span, err) }()
+
+	if err := normalizeFilter(&filter); err != nil {
+		return err
+	}
+	filter.Cursor, filter.Limit = "", 0
+	return s.repository.ScanUserURLS(ctx, userID, filter, fn)
 }
 
 // get stats
-func (s *urlService) GetStats() (models.StatRecord, error) {
-	return s.repository.GetStats()
+func (s *urlService) GetStats(ctx context.Context) (_ models.StatRecord, err error) {
+	ctx, span := startSpan(ctx, "GetStats")
+	defer func() { tracing.End(span, err) }()
+
+	// pending redirects are flushed to be counted in stats
+	s.flushRedirects(ctx)
+
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	return s.repository.GetStats(ctx)
+}
+
+// get daily counts in [from, to) range
+func (s *urlService) GetTimeSeries(ctx context.Context, from, to time.Time) (_ []models.TimeSeriesPoint, err error) {
+	ctx, span := startSpan(ctx, "GetTimeSeries")
+	defer func() { tracing.End(span, err) }()
+
+	// pending redirects are flushed to be counted in time series
+	s.flushRedirects(ctx)
+
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	return s.repository.GetTimeSeries(ctx, from, to)
 }
 
-// delete urls
-func (s *urlService) DeleteURLS(urls []string, userID string) error {
+// delete urls asynchronously, deletion is not cancelled with request context
+func (s *urlService) DeleteURLS(ctx context.Context, urls []string, userID string) error {
+	ctx, span := startSpan(ctx, "DeleteURLS", attribute.Int("shortener.urls", len(urls)))
+	defer span.End()
+
 	s.wg.Add(1)
-	go s.deleteURLSAsync(urls, userID)
+	metrics.PendingDeletions.Inc()
+	go s.deleteURLSAsync(context.WithoutCancel(ctx), urls, userID)
 	return nil
 }
 
-// ping database
-func (s *urlService) PingDB() error {
-	return s.db.Ping()
+// check component with ping function
+func checkComponent(ctx context.Context, name, backend string, ping func(ctx context.Context) error) models.ComponentHealth {
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := ping(ctx)
+	component := models.ComponentHealth{Name: name, Backend: backend, Status: models.HealthOK, Latency: time.Since(start).String()}
+	if err != nil {
+		logger.FromContext(ctx).Error("component is unhealthy", zap.String("component", name), zap.String("error", err.Error()))
+		component.Status = models.HealthFail
+		component.Error = err.Error()
+	}
+	return component
+}
+
+// health of storage and database connection when it is used
+func (s *urlService) Health(ctx context.Context) models.HealthReport {
+	ctx, span := startSpan(ctx, "Health")
+	defer span.End()
+
+	report := models.HealthReport{Status: models.HealthOK}
+	report.Components = append(report.Components, checkComponent(ctx, "storage", s.backend, s.repository.Ping))
+	if s.db != nil {
+		report.Components = append(report.Components, checkComponent(ctx, "database", "", s.db.PingContext))
+	}
+	for _, component := range report.Components {
+		if component.Status != models.HealthOK {
+			report.Status = models.HealthFail
+		}
+	}
+	return report
+}
+
+// check storage backend is reachable
+func (s *urlService) Ready(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "Ready")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := readContext(ctx)
+	defer cancel()
+	return s.repository.Ping(ctx)
 }
 
 // close instance
 func (s *urlService) Close() error {
 	s.wg.Wait()
+	close(s.stop)
+	s.loops.Wait()
+	s.flushRedirects(context.Background())
+	s.unregisterDBStats()
+	s.unregisterCacheStats()
+	s.policy.Close()
 	if s.db != nil {
 		s.db.Close()
 	}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/rutkin/url-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// repository where first short urls are taken by other user right before they are created
+type collidingRepository struct {
+	repository.Repository
+	collisions int
+	taken      []string
+}
+
+func (r *collidingRepository) CreateURL(ctx context.Context, record repository.URLRecord) error {
+	if len(r.taken) < r.collisions {
+		r.taken = append(r.taken, record.ID)
+		err := r.Repository.CreateURL(ctx, repository.URLRecord{ID: record.ID, URL: "https://other.example/", UserID: "other"})
+		if err != nil {
+			return err
+		}
+	}
+	return r.Repository.CreateURL(ctx, record)
+}
+
+func TestCreateRandomURLCollision(t *testing.T) {
+	ctx := context.Background()
+	storage, err := repository.NewInFileRepository(filepath.Join(t.TempDir(), "shortener.json"))
+	require.NoError(t, err)
+	defer storage.Close()
+
+	r := &collidingRepository{Repository: storage, collisions: randomShortURLAttempts - 1}
+	s := &urlService{repository: r}
+	record := repository.URLRecord{URL: "https://mine.example/", UserID: "user", Title: "Mine"}
+	require.NoError(t, s.createRandomURL(ctx, &record))
+	require.Len(t, r.taken, randomShortURLAttempts-1)
+	assert.NotContains(t, r.taken, record.ID)
+
+	for _, id := range r.taken {
+		url, err := storage.GetURL(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "other", url.UserID)
+		assert.Equal(t, "https://other.example/", url.URL)
+	}
+	url, err := storage.GetURL(ctx, record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user", url.UserID)
+	assert.Equal(t, "Mine", url.Title)
+
+	t.Run("no_free_short_url", func(t *testing.T) {
+		s := &urlService{repository: &collidingRepository{Repository: storage, collisions: randomShortURLAttempts}}
+		record := repository.URLRecord{URL: "https://mine.example/", UserID: "user"}
+		assert.ErrorIs(t, s.createRandomURL(ctx, &record), errNoFreeShortURL)
+	})
+}
@@ -0,0 +1,221 @@
+// urlnorm package validates destination urls and normalizes them,
+// so the same logical url is stored once and gets the same short id
+package urlnorm
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidURL - url is rejected by normalizer, returned errors wrap it
+var ErrInvalidURL = errors.New("invalid url")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Options - normalizer settings
+type Options struct {
+	// allowed lowercase schemes, for example http and https
+	AllowedSchemes []string
+	// max length of url in bytes, 0 disables check
+	MaxLength int
+	// query parameters removed from url, name with trailing * matches prefix
+	TrackingParams []string
+}
+
+// step of normalization pipeline, it validates and changes parsed url in place
+type step func(u *url.URL) error
+
+// Normalizer - url validation and normalization pipeline
+type Normalizer struct {
+	maxLength int
+	steps     []step
+}
+
+// create new instance of normalizer
+func NewNormalizer(opts Options) *Normalizer {
+	n := &Normalizer{maxLength: opts.MaxLength}
+	n.steps = []step{
+		schemeStep(opts.AllowedSchemes),
+		hostStep,
+		portStep,
+		trackingStep(opts.TrackingParams),
+	}
+	return n
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidURL, fmt.Sprintf(format, args...))
+}
+
+// validate and normalize raw url
+func (n *Normalizer) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", invalid("empty url")
+	}
+	if n.maxLength > 0 && len(raw) > n.maxLength {
+		return "", invalid("url is longer than %d bytes", n.maxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	for _, step := range n.steps {
+		if err := step(u); err != nil {
+			return "", err
+		}
+	}
+
+	result := u.String()
+	if n.maxLength > 0 && len(result) > n.maxLength {
+		return "", invalid("url is longer than %d bytes", n.maxLength)
+	}
+	return result, nil
+}
+
+// scheme must be in allowlist, url.Parse already lowercases it
+func schemeStep(allowed []string) step {
+	schemes := make(map[string]bool, len(allowed))
+	for _, scheme := range allowed {
+		schemes[strings.ToLower(strings.TrimSpace(scheme))] = true
+	}
+	return func(u *url.URL) error {
+		if u.Scheme == "" {
+			return invalid("scheme is missing")
+		}
+		if !schemes[u.Scheme] {
+			return invalid("scheme '%s' is not allowed", u.Scheme)
+		}
+		if u.Opaque != "" {
+			return invalid("url without host")
+		}
+		return nil
+	}
+}
+
+// host is converted to lowercase punycode, domain names must have top level domain;
+// userinfo is rejected, it hides real host of links like https://bank.com@evil.com
+func hostStep(u *url.URL) error {
+	if u.User != nil {
+		return invalid("url with userinfo")
+	}
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	if host == "" {
+		return invalid("host is missing")
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return invalid("host '%s': %s", host, err.Error())
+		}
+		dot := strings.LastIndexByte(ascii, '.')
+		if dot <= 0 || !isTLD(ascii[dot+1:]) {
+			return invalid("host '%s' has no top level domain", host)
+		}
+		host = ascii
+	}
+
+	switch port := u.Port(); {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+	return nil
+}
+
+// top level domain is letters or punycode label
+func isTLD(label string) bool {
+	if len(label) < 2 {
+		return false
+	}
+	if strings.HasPrefix(label, "xn--") {
+		return true
+	}
+	for _, c := range label {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// default port of scheme is removed
+func portStep(u *url.URL) error {
+	port := u.Port()
+	if port == "" {
+		return nil
+	}
+	if port == defaultPorts[u.Scheme] {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	return nil
+}
+
+// tracking parameters are removed, order of other parameters is kept
+func trackingStep(params []string) step {
+	var exact []string
+	var prefixes []string
+	for _, param := range params {
+		param = strings.ToLower(strings.TrimSpace(param))
+		if param == "" {
+			continue
+		}
+		if strings.HasSuffix(param, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(param, "*"))
+		} else {
+			exact = append(exact, param)
+		}
+	}
+	isTracking := func(name string) bool {
+		name = strings.ToLower(name)
+		for _, param := range exact {
+			if name == param {
+				return true
+			}
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(u *url.URL) error {
+		u.ForceQuery = false
+		if u.RawQuery == "" {
+			return nil
+		}
+		parts := strings.Split(u.RawQuery, "&")
+		kept := parts[:0]
+		for _, part := range parts {
+			if part == "" {
+				continue
+			}
+			name, _, _ := strings.Cut(part, "=")
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+			if !isTracking(name) {
+				kept = append(kept, part)
+			}
+		}
+		u.RawQuery = strings.Join(kept, "&")
+		return nil
+	}
+}